@@ -0,0 +1,15 @@
+package rdt
+
+import "net/netip"
+
+const network = "rdt"
+
+// Addr is the address of an endpoint of a reliable data transfer connection
+type Addr struct {
+	netip.AddrPort
+}
+
+// Network returns the name of the network, "rdt"
+func (a Addr) Network() string {
+	return network
+}
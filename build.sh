@@ -1,4 +1,6 @@
 #!/bin/bash -eu
 
 GOOS=$1 GOARCH=$2 go build -o "bin/$1/$2/server" cmd/server/main.go && echo "BUILD: server TARGET: $1/$2"
-GOOS=$1 GOARCH=$2 go build -o "bin/$1/$2/client" cmd/client/main.go && echo "BUILD: client TARGET: $1/$2"
\ No newline at end of file
+GOOS=$1 GOARCH=$2 go build -o "bin/$1/$2/client" cmd/client/main.go && echo "BUILD: client TARGET: $1/$2"
+GOOS=$1 GOARCH=$2 go build -o "bin/$1/$2/rdt-trace" cmd/rdt-trace/main.go && echo "BUILD: rdt-trace TARGET: $1/$2"
+GOOS=$1 GOARCH=$2 go build -o "bin/$1/$2/rdtperf" cmd/rdtperf/main.go && echo "BUILD: rdtperf TARGET: $1/$2"
\ No newline at end of file
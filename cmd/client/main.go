@@ -1,63 +1,77 @@
 package main
 
 import (
-	"bufio"
-	"errors"
+	"context"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net"
-	"net/netip"
 	"os"
+	"path/filepath"
+	"rdt"
 	"rdt/internal/config"
-	"rdt/internal/gbn"
-	"unicode"
+	"rdt/internal/logging"
+	"rdt/internal/transfer"
+	"strconv"
 )
 
 func main() {
-	if len(os.Args) != 2 {
-		log.Fatalln("Usage: client <servername>")
+	logFlags := logging.RegisterFlags(flag.CommandLine)
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalln("Failed to load config:", err)
+	}
+	logger, level, err := logFlags.Logger(os.Stderr)
+	if err != nil {
+		log.Fatalln("Failed to set up logging:", err)
+	}
+	slog.SetDefault(logger)
+	logging.ToggleTraceOnSignal(logger, level)
+	cfg.Logger = logger
+	var path string
+	switch {
+	case flag.NArg() == 3 && flag.Arg(0) == "send":
+		path = flag.Arg(1)
+	case flag.NArg() != 1:
+		log.Fatalln("Usage: client [flags] <servername>\n       client [flags] send <file> <servername>")
 	}
 
-	serverAddr := serverAddress()
+	serverAddr := net.JoinHostPort(flag.Arg(flag.NArg()-1), strconv.Itoa(int(cfg.Port)))
 
-	fmt.Println("Press CTRL-D to stop...")
+	if path == "" {
+		fmt.Println("Press CTRL-D to stop...")
+	}
 
-	transport := gbn.NewClientTransport()
-	transport.Start()
-	defer transport.Stop()
-	transport.RegisterAddress(serverAddr)
+	conn, err := rdt.DialConfig(context.Background(), serverAddr, cfg)
+	if err != nil {
+		log.Fatalln("Failed to connect to server:", err)
+	}
+	logger.Info("connected", "server", conn.RemoteAddr())
 
-	consoleReader := bufio.NewReader(os.Stdin)
-	for {
-		r, _, err := consoleReader.ReadRune()
-		if err != nil {
-			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
-				return
-			}
-			log.Fatalln(err)
-		}
-		// ignore whitespace characters
-		if !unicode.IsSpace(r) {
-			transport.InputChan() <- r
-		}
+	if path != "" {
+		sendFile(conn, path)
+	} else if _, err := io.Copy(conn, os.Stdin); err != nil {
+		log.Fatalln(err)
+	}
+	// wait until everything typed so far is delivered
+	if err := conn.Close(); err != nil {
+		log.Fatalln("Failed to close connection:", err)
 	}
 }
 
-func serverAddress() netip.AddrPort {
-	serverName := os.Args[1]
-	serverIpAddrs, err := net.LookupIP(serverName)
+// sendFile sends the file at path over conn, continuing an interrupted transfer,
+// and shows its progress until the server verified it
+func sendFile(conn net.Conn, path string) {
+	var meter transfer.Meter
+	name := filepath.Base(path)
+	err := transfer.Send(conn, path, func(done, total int64) {
+		fmt.Fprintf(os.Stderr, "\rsending %s: %s\033[K", name, meter.Format(done, total))
+	})
+	fmt.Fprintln(os.Stderr)
 	if err != nil {
-		log.Fatalln("Failed to parse server address:", err)
-	}
-	for i := range serverIpAddrs {
-		serverIpAddrs[i] = serverIpAddrs[i].To16()
-	}
-	log.Println("Server addresses:", serverIpAddrs)
-	serverIpAddr, ok := netip.AddrFromSlice(serverIpAddrs[0])
-	if !ok {
-		log.Fatalln("Failed to parse server address into netip.AddrPort")
+		log.Fatalln("Failed to send file:", err)
 	}
-	serverAddrPort := netip.AddrPortFrom(serverIpAddr, config.PortNumber)
-	return serverAddrPort
+	fmt.Printf("Sent %s\n", name)
 }
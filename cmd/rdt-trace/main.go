@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"rdt/internal/trace"
+	"strings"
+	"time"
+)
+
+// point is an event placed on the sequence no. vs time chart
+type point struct {
+	at     time.Duration // since the first event of the connection
+	seq    int64         // sequence no. counted from the first one charted, unwrapped
+	marker byte
+}
+
+// markers of the chart, in increasing order of precedence when they overlap
+var markers = []struct {
+	marker byte
+	color  string
+	label  string
+}{
+	{'-', "#999999", "window base after ack"},
+	{'.', "#1f77b4", "sent / accepted"},
+	{'R', "#ff7f0e", "retransmitted"},
+	{'x', "#d62728", "timer expired / discarded"},
+}
+
+func main() {
+	connID := flag.Uint64("conn", 0, "connection to show, where 0 shows all in the timeline and the first with data in the chart")
+	chart := flag.String("chart", "", "print a sequence no. vs time chart instead of the timeline (ascii|svg)")
+	side := flag.String("side", "send", "direction to chart, by the sequence nos. sent or those received (send|recv)")
+	width := flag.Int("width", 100, "width of the ascii chart in columns")
+	height := flag.Int("height", 30, "height of the ascii chart in rows")
+	stall := flag.Duration("stall", 200*time.Millisecond, "gap between events that the timeline marks as a stall")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: rdt-trace [flags] [trace file, read from stdin if omitted]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	in := io.Reader(os.Stdin)
+	if flag.NArg() > 0 && flag.Arg(0) != "-" {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatalln("Failed to open trace:", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	events, err := trace.Read(in)
+	if err != nil {
+		log.Fatalln("Failed to read trace:", err)
+	}
+	if len(events) == 0 {
+		log.Fatalln("Trace has no events")
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	switch *chart {
+	case "":
+		printTimeline(out, events, *connID, *stall)
+	case "ascii", "svg":
+		if *side != "send" && *side != "recv" {
+			log.Fatalln("Unknown side:", *side)
+		}
+		id, points := chartPoints(events, *connID, *side == "send")
+		if len(points) == 0 {
+			log.Fatalln("No data messages to chart")
+		}
+		title := fmt.Sprintf("conn %d, sequence nos. %s counted from the first", id, map[bool]string{true: "sent", false: "received"}[*side == "send"])
+		if *chart == "ascii" {
+			printASCII(out, title, points, *width, *height)
+		} else {
+			printSVG(out, title, points)
+		}
+	default:
+		log.Fatalln("Unknown chart:", *chart)
+	}
+}
+
+// printTimeline prints every event of conn, or of all connections if conn is 0,
+// marking gaps of at least stall and ending with a summary per connection
+func printTimeline(w io.Writer, events []trace.Event, conn uint64, stall time.Duration) {
+	type summary struct {
+		peer                                 string
+		sent, resent, bursts, expired, drops int
+	}
+	var order []uint64
+	summaries := map[uint64]*summary{}
+	start := events[0].Time
+	var prev time.Time
+	for _, e := range events {
+		if conn != 0 && e.Conn != conn {
+			continue
+		}
+		if !prev.IsZero() && e.Time.Sub(prev) >= stall {
+			fmt.Fprintf(w, "%14s  --- stalled for %v ---\n", "", e.Time.Sub(prev).Round(time.Microsecond))
+		}
+		prev = e.Time
+		fmt.Fprintf(w, "%14s  conn %-3d %-12s %s\n", e.Time.Sub(start).Round(time.Microsecond), e.Conn, e.Kind, details(e))
+
+		s, ok := summaries[e.Conn]
+		if !ok {
+			s = &summary{peer: e.Peer}
+			summaries[e.Conn] = s
+			order = append(order, e.Conn)
+		}
+		switch e.Kind {
+		case trace.KindSend:
+			s.sent++
+		case trace.KindRetransmit:
+			s.resent += int(e.Count)
+			s.bursts++
+		case trace.KindTimerExpire:
+			s.expired++
+		case trace.KindDiscard:
+			s.drops++
+		}
+	}
+	fmt.Fprintln(w)
+	for _, id := range order {
+		s := summaries[id]
+		fmt.Fprintf(w, "conn %d with %s: %d sent, %d retransmitted in %d bursts, %d timeouts, %d discarded\n",
+			id, s.peer, s.sent, s.resent, s.bursts, s.expired, s.drops)
+	}
+}
+
+// details formats the fields of e that apply to its kind
+func details(e trace.Event) string {
+	var b strings.Builder
+	if e.Kind == trace.KindState {
+		fmt.Fprintf(&b, "%s peer=%s", e.Reason, e.Peer)
+		if e.MaxSeqNo != 0 {
+			fmt.Fprintf(&b, " isn=%d window=%d max_seq=%d", e.Seq, e.Window, e.MaxSeqNo)
+		}
+		return b.String()
+	}
+	fmt.Fprintf(&b, "seq=%d", e.Seq)
+	if e.Count != 0 {
+		fmt.Fprintf(&b, " count=%d", e.Count)
+	}
+	if e.Kind == trace.KindSend || e.Kind == trace.KindWindow || e.Kind == trace.KindRetransmit {
+		fmt.Fprintf(&b, " in_flight=%d", e.InFlight)
+	}
+	if e.Window != 0 {
+		fmt.Fprintf(&b, " window=%d", e.Window)
+	}
+	if e.RTO != 0 {
+		fmt.Fprintf(&b, " rto=%v", e.RTO.Round(time.Microsecond))
+	}
+	if e.Reason != "" {
+		fmt.Fprintf(&b, " reason=%s", e.Reason)
+	}
+	return b.String()
+}
+
+// chartPoints places the events of conn on the chart, choosing the first connection with data if conn is 0.
+// The sequence nos. sent are charted if send is set, otherwise those received.
+func chartPoints(events []trace.Event, conn uint64, send bool) (uint64, []point) {
+	var start time.Time
+	var points []point
+	maxSeqNo := uint64(1) << 32
+	var first, last int64
+	for _, e := range events {
+		if conn != 0 && e.Conn != conn {
+			continue
+		}
+		if e.Kind == trace.KindState && e.MaxSeqNo != 0 {
+			maxSeqNo = uint64(e.MaxSeqNo)
+		}
+		var marker byte
+		switch e.Kind {
+		case trace.KindSend, trace.KindRetransmit, trace.KindWindow, trace.KindTimerExpire:
+			if !send {
+				continue
+			}
+			marker = map[trace.Kind]byte{trace.KindSend: '.', trace.KindRetransmit: 'R', trace.KindWindow: '-', trace.KindTimerExpire: 'x'}[e.Kind]
+		case trace.KindAccept, trace.KindDiscard:
+			if send {
+				continue
+			}
+			marker = map[trace.Kind]byte{trace.KindAccept: '.', trace.KindDiscard: 'x'}[e.Kind]
+		default:
+			continue
+		}
+		if len(points) == 0 {
+			// stick to the first connection with data
+			conn = e.Conn
+			start = e.Time
+			first, last = int64(e.Seq), int64(e.Seq)
+		}
+		// unwrap the sequence no. into the direction of the smallest step from the last one
+		m := int64(maxSeqNo)
+		step := uint64(((int64(e.Seq)-last)%m + m) % m)
+		seq := last + int64(step)
+		if step > maxSeqNo/2 {
+			seq -= int64(maxSeqNo)
+		}
+		last = seq
+		count := max(int64(e.Count), 1)
+		for i := int64(0); i < count; i++ {
+			points = append(points, point{at: e.Time.Sub(start), seq: seq - first + i, marker: marker})
+		}
+	}
+	return conn, points
+}
+
+// bounds obtains the ranges of time and sequence nos. covered by points
+func bounds(points []point) (end time.Duration, low, high int64) {
+	low, high = math.MaxInt64, math.MinInt64
+	for _, p := range points {
+		end = max(end, p.at)
+		low = min(low, p.seq)
+		high = max(high, p.seq)
+	}
+	return max(end, time.Microsecond), low, max(high, low+1)
+}
+
+// precedence ranks marker among overlapping markers
+func precedence(marker byte) int {
+	for i, m := range markers {
+		if m.marker == marker {
+			return i
+		}
+	}
+	return -1
+}
+
+// printASCII draws points on a grid of width columns and height rows
+func printASCII(w io.Writer, title string, points []point, width, height int) {
+	width, height = max(width, 10), max(height, 5)
+	end, low, high := bounds(points)
+	grid := make([][]byte, height)
+	for i := range grid {
+		grid[i] = []byte(strings.Repeat(" ", width))
+	}
+	for _, p := range points {
+		col := int(int64(p.at) * int64(width-1) / int64(end))
+		row := height - 1 - int((p.seq-low)*int64(height-1)/(high-low))
+		if cell := &grid[row][col]; precedence(p.marker) > precedence(*cell) {
+			*cell = p.marker
+		}
+	}
+	fmt.Fprintln(w, title)
+	for i, line := range grid {
+		label := ""
+		switch i {
+		case 0:
+			label = fmt.Sprint(high)
+		case height - 1:
+			label = fmt.Sprint(low)
+		}
+		fmt.Fprintf(w, "%8s |%s\n", label, line)
+	}
+	fmt.Fprintf(w, "%8s +%s\n", "", strings.Repeat("-", width))
+	endLabel := end.Round(time.Microsecond).String()
+	fmt.Fprintf(w, "%8s  0%s%s\n", "", strings.Repeat(" ", max(width-1-len(endLabel), 1)), endLabel)
+	var legend []string
+	for _, m := range markers {
+		legend = append(legend, fmt.Sprintf("%c %s", m.marker, m.label))
+	}
+	fmt.Fprintf(w, "%8s  %s\n", "", strings.Join(legend, "   "))
+}
+
+// printSVG draws points as an SVG image with axes and a legend
+func printSVG(w io.Writer, title string, points []point) {
+	const width, height, left, right, top, bottom = 960, 540, 80, 20, 40, 70
+	end, low, high := bounds(points)
+	x := func(at time.Duration) float64 {
+		return left + float64(at)*(width-left-right)/float64(end)
+	}
+	y := func(seq int64) float64 {
+		return height - bottom - float64(seq-low)*(height-top-bottom)/float64(high-low)
+	}
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`+"\n", width, height)
+	fmt.Fprintf(w, `<rect width="%d" height="%d" fill="white"/>`+"\n", width, height)
+	fmt.Fprintf(w, `<text x="%d" y="24" font-size="16">%s</text>`+"\n", left, title)
+	// axes with ticks
+	fmt.Fprintf(w, `<path d="M%d %d V%d H%d" fill="none" stroke="black"/>`+"\n", left, top, height-bottom, width-right)
+	const ticks = 5
+	for i := 0; i <= ticks; i++ {
+		at := end * time.Duration(i) / ticks
+		seq := low + (high-low)*int64(i)/ticks
+		fmt.Fprintf(w, `<text x="%.1f" y="%d" text-anchor="middle">%v</text>`+"\n", x(at), height-bottom+18, at.Round(time.Microsecond))
+		fmt.Fprintf(w, `<text x="%d" y="%.1f" text-anchor="end">%d</text>`+"\n", left-6, y(seq)+4, seq)
+	}
+	fmt.Fprintf(w, `<text x="%d" y="%d" text-anchor="middle">time</text>`+"\n", (width+left)/2, height-bottom+36)
+	// points in increasing order of precedence so that the important ones stay visible
+	for _, m := range markers {
+		for _, p := range points {
+			if p.marker == m.marker {
+				fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="2.5" fill="%s"/>`+"\n", x(p.at), y(p.seq), m.color)
+			}
+		}
+	}
+	for i, m := range markers {
+		lx := left + i*200
+		fmt.Fprintf(w, `<circle cx="%d" cy="%d" r="4" fill="%s"/><text x="%d" y="%d">%s</text>`+"\n", lx, height-14, m.color, lx+8, height-10, m.label)
+	}
+	fmt.Fprintln(w, "</svg>")
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"rdt"
+	"rdt/internal/config"
+	"rdt/internal/gbn"
+	"rdt/internal/logging"
+	"rdt/internal/message"
+	"rdt/internal/simnet"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sampleInterval is the interval at which the gauges of the connection are sampled
+const sampleInterval = 10 * time.Millisecond
+
+// options of a benchmark run
+type options struct {
+	duration    time.Duration // time to send for
+	bytes       int64         // payload bytes to send, where 0 sends until duration elapsed
+	payloadSize int           // bytes per message
+	interval    time.Duration // time between interval reports, where 0 disables them
+	json        bool          // report as JSON instead of text
+	quiet       bool          // leave reporting what was received to the client
+}
+
+// Result of a benchmark run, measured at the client
+type Result struct {
+	Network               string        `json:"network"`
+	Mode                  string        `json:"mode"`
+	CongestionControl     string        `json:"congestion_control"`
+	WindowSize            uint32        `json:"window_size"`
+	MaxSeqNo              uint32        `json:"max_seq_no"`
+	MinRTO                time.Duration `json:"min_rto_ns"`
+	MaxRTO                time.Duration `json:"max_rto_ns"`
+	PayloadSize           int           `json:"payload_size"`
+	Duration              time.Duration `json:"duration_ns"`    // from the handshake until all data is acked
+	Bytes                 uint64        `json:"bytes"`          // payload bytes delivered
+	Goodput               float64       `json:"goodput_bps"`    // payload bits delivered per second
+	Throughput            float64       `json:"throughput_bps"` // payload bits sent per second, including retransmissions
+	PacketsSent           uint64        `json:"packets_sent"`
+	Retransmissions       uint64        `json:"retransmissions"`
+	RetransmissionRatio   float64       `json:"retransmission_ratio"`
+	Timeouts              uint64        `json:"timeouts"`
+	DupAcks               uint64        `json:"dup_acks"`
+	RTT                   Percentiles   `json:"rtt_ns"`                  // of the round trip times measured on acks of messages sent once
+	WindowUtilisation     float64       `json:"window_utilisation"`      // mean share of the window size in flight
+	SendWindowUtilisation float64       `json:"send_window_utilisation"` // mean share of the effective send window in flight, each sample divided by max(window, in flight)
+	MeanCongestionWindow  float64       `json:"mean_congestion_window"`
+}
+
+// Percentiles of a distribution of durations
+type Percentiles struct {
+	Min time.Duration `json:"min"`
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+func main() {
+	server := flag.Bool("s", false, "run as server, receiving from any number of clients until interrupted")
+	client := flag.String("c", "", "run as client, sending to the server on this host")
+	sim := flag.Bool("sim", false, "run both server and client in process over the in-memory network")
+	duration := flag.Duration("t", 10*time.Second, "time to send for")
+	bytes := flag.Int64("n", 0, "payload bytes to send, stopping early once sent, 0 sends for the whole time")
+	payloadSize := flag.Int("l", message.MaxPayloadSize, "payload bytes per message")
+	interval := flag.Duration("i", time.Second, "time between interval reports of the client, 0 disables them")
+	jsonOutput := flag.Bool("json", false, "report the result as JSON")
+	loss := flag.Float64("loss", 0, "probability that the in-memory network drops a datagram")
+	delay := flag.Duration("delay", 0, "one-way delay of the in-memory network")
+	jitter := flag.Duration("jitter", 0, "maximum random variation of the delay of the in-memory network")
+	bandwidth := flag.Int("bandwidth", 0, "bytes per second each endpoint of the in-memory network sends at most, 0 is unlimited")
+	seed := flag.Uint64("seed", 1, "seed of the in-memory network")
+	logFlags := logging.RegisterFlags(flag.CommandLine)
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalln("Failed to load config:", err)
+	}
+	logger, level, err := logFlags.Logger(os.Stderr)
+	if err != nil {
+		log.Fatalln("Failed to set up logging:", err)
+	}
+	slog.SetDefault(logger)
+	logging.ToggleTraceOnSignal(logger, level)
+	cfg.Logger = logger
+	if *payloadSize < 1 || *payloadSize > message.MaxPayloadSize {
+		log.Fatalf("Payload size must be between 1 and %d bytes\n", message.MaxPayloadSize)
+	}
+	opts := options{duration: *duration, bytes: *bytes, payloadSize: *payloadSize, interval: *interval, json: *jsonOutput}
+
+	switch {
+	case *server:
+		serve(cfg, opts)
+	case *client != "":
+		addr, err := rdt.ResolveAddr(context.Background(), net.JoinHostPort(*client, strconv.Itoa(int(cfg.Port))))
+		if err != nil {
+			log.Fatalln("Failed to resolve server:", err)
+		}
+		rtts := &rttSamples{}
+		cfg.OnRTTSample = rtts.add
+		t, err := gbn.NewClientTransport(cfg)
+		if err != nil {
+			log.Fatalln("Failed to create transport:", err)
+		}
+		t.Start()
+		defer t.Abort()
+		report(run(t, addr, "udp", cfg, opts, rtts), opts)
+	case *sim:
+		network := simnet.New(simnet.Config{Seed: *seed, Loss: *loss, Delay: *delay, Jitter: *jitter, Bandwidth: *bandwidth})
+		go network.Start()
+		defer network.Stop()
+		laddr := netip.AddrPortFrom(netip.IPv6Unspecified(), cfg.Port)
+		sconn, err := network.Listen(laddr)
+		if err != nil {
+			log.Fatalln("Failed to listen:", err)
+		}
+		st, err := gbn.NewTransport(sconn, true, cfg)
+		if err != nil {
+			log.Fatalln("Failed to create server transport:", err)
+		}
+		st.Start()
+		defer st.Abort()
+		// the client reports for both ends
+		serverOpts := opts
+		serverOpts.quiet = true
+		go discardAll(st, serverOpts)
+		cconn, err := network.Listen(netip.AddrPort{})
+		if err != nil {
+			log.Fatalln("Failed to listen:", err)
+		}
+		rtts := &rttSamples{}
+		cfg.OnRTTSample = rtts.add
+		ct, err := gbn.NewTransport(cconn, false, cfg)
+		if err != nil {
+			log.Fatalln("Failed to create client transport:", err)
+		}
+		ct.Start()
+		defer ct.Abort()
+		report(run(ct, netip.AddrPortFrom(netip.IPv6Loopback(), cfg.Port), "simnet", cfg, opts, rtts), opts)
+	default:
+		log.Fatalln("Usage: rdtperf [flags] -s\n       rdtperf [flags] -c <servername>\n       rdtperf [flags] -sim")
+	}
+}
+
+// serve receives from clients over UDP until interrupted
+func serve(cfg gbn.Config, opts options) {
+	t, err := gbn.NewServerTransport(netip.AddrPortFrom(netip.IPv6Unspecified(), cfg.Port), cfg)
+	if err != nil {
+		log.Fatalln("Failed to listen:", err)
+	}
+	t.Start()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		t.Abort()
+	}()
+	if !opts.json {
+		fmt.Printf("Server listening on port %d\n", cfg.Port)
+	}
+	discardAll(t, opts)
+}
+
+// discardAll discards everything received on every connection accepted by t,
+// reporting what was received once a connection is closed
+func discardAll(t *gbn.Transport, opts options) {
+	for {
+		select {
+		case <-t.Done():
+			return
+		case conn := <-t.AcceptChan():
+			go discard(conn, opts)
+		}
+	}
+}
+
+// discard discards everything received on conn until it is closed
+func discard(conn *gbn.Conn, opts options) {
+	var received int64
+	var start time.Time
+loop:
+	for {
+		select {
+		case payload := <-conn.OutputChan():
+			if start.IsZero() {
+				start = time.Now()
+			}
+			received += int64(len(payload))
+		case <-conn.Done():
+			break loop
+		}
+	}
+	if opts.quiet {
+		return
+	}
+	elapsed := time.Since(start)
+	if start.IsZero() {
+		elapsed = 0
+	}
+	goodput := 0.0
+	if elapsed > 0 {
+		goodput = float64(received) * 8 / elapsed.Seconds()
+	}
+	if opts.json {
+		_ = json.NewEncoder(os.Stdout).Encode(map[string]any{
+			"peer": conn.RemoteAddr().String(), "bytes": received, "duration_ns": elapsed, "goodput_bps": goodput,
+		})
+		return
+	}
+	fmt.Printf("%v: received %d bytes in %v, %s\n", conn.RemoteAddr(), received, elapsed.Round(time.Millisecond), formatBits(goodput))
+}
+
+// rttSamples collects the round trip times measured by a transport
+type rttSamples struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+func (r *rttSamples) add(_ netip.AddrPort, rtt time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples = append(r.samples, rtt)
+}
+
+// run sends to the server at addr over t as configured by opts and measures the connection,
+// whose round trip times t reports to rtts
+func run(t *gbn.Transport, addr netip.AddrPort, network string, cfg gbn.Config, opts options, rtts *rttSamples) Result {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.HandshakeTimeout*time.Duration(cfg.HandshakeRetries+1))
+	conn, err := t.Dial(ctx, addr)
+	cancel()
+	if err != nil {
+		log.Fatalln("Failed to connect to server:", err)
+	}
+	if !opts.json {
+		fmt.Printf("Connected to %v, sending for %v\n", conn.RemoteAddr(), opts.duration)
+	}
+	start := time.Now()
+
+	// sample the gauges of the connection while sending
+	var inFlight, sendWindow, cwnd []float64
+	stopSampling := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(sampleInterval)
+		defer ticker.Stop()
+		var last gbn.ConnStats
+		lastReport := start
+		for {
+			select {
+			case <-stopSampling:
+				return
+			case now := <-ticker.C:
+				stats := conn.Stats()
+				inFlight = append(inFlight, float64(stats.InFlight))
+				// the send window may shrink below the messages already in flight, which counts as full
+				if limit := max(stats.SendWindow, stats.InFlight); limit > 0 {
+					sendWindow = append(sendWindow, float64(stats.InFlight)/float64(limit))
+				}
+				cwnd = append(cwnd, float64(stats.CongestionWindow))
+				if opts.interval > 0 && !opts.json && now.Sub(lastReport) >= opts.interval {
+					delivered := float64(acked(stats)-acked(last)) * float64(opts.payloadSize)
+					fmt.Printf("[%6.1fs] %s, %d retransmitted, cwnd %d, srtt %v\n",
+						now.Sub(start).Seconds(), formatBits(delivered*8/now.Sub(lastReport).Seconds()),
+						stats.Retransmissions-last.Retransmissions, stats.CongestionWindow, stats.SRTT.Round(time.Microsecond))
+					last, lastReport = stats, now
+				}
+			}
+		}
+	}()
+
+	// send until the time is up or enough was sent, then wait until everything is acked
+	payload := make([]byte, opts.payloadSize)
+	sendCtx, cancel := context.WithTimeout(context.Background(), opts.duration)
+	var sent int64
+	for opts.bytes == 0 || sent < opts.bytes {
+		size := int64(opts.payloadSize)
+		if opts.bytes > 0 {
+			size = min(size, opts.bytes-sent)
+		}
+		if err := conn.Send(sendCtx, payload[:size]); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				break
+			}
+			log.Fatalln("Failed to send:", err)
+		}
+		sent += size
+	}
+	cancel()
+	closeCtx, cancel := context.WithTimeout(context.Background(), cfg.CloseTimeout)
+	err = conn.Close(closeCtx)
+	cancel()
+	if err != nil {
+		log.Fatalln("Failed to close connection:", err)
+	}
+	elapsed := time.Since(start)
+	close(stopSampling)
+	<-sampled
+
+	stats := conn.Stats()
+	rtts.mu.Lock()
+	defer rtts.mu.Unlock()
+	result := Result{
+		Network:               network,
+		Mode:                  cfg.Mode.String(),
+		CongestionControl:     cfg.CongestionControl,
+		WindowSize:            cfg.WindowSize,
+		MaxSeqNo:              cfg.MaxSeqNo,
+		MinRTO:                cfg.MinRTO,
+		MaxRTO:                cfg.MaxRTO,
+		PayloadSize:           opts.payloadSize,
+		Duration:              elapsed,
+		Bytes:                 uint64(sent),
+		Goodput:               float64(sent) * 8 / elapsed.Seconds(),
+		Throughput:            float64(stats.BytesSent) * 8 / elapsed.Seconds(),
+		PacketsSent:           stats.PacketsSent,
+		Retransmissions:       stats.Retransmissions,
+		Timeouts:              stats.Timeouts,
+		DupAcks:               stats.DupAcks,
+		RTT:                   percentiles(rtts.samples),
+		WindowUtilisation:     mean(inFlight) / float64(cfg.WindowSize),
+		SendWindowUtilisation: mean(sendWindow),
+		MeanCongestionWindow:  mean(cwnd),
+	}
+	if stats.PacketsSent > 0 {
+		result.RetransmissionRatio = float64(stats.Retransmissions) / float64(stats.PacketsSent)
+	}
+	return result
+}
+
+// acked obtains the data messages acked so far, which are all first transmissions no longer in flight
+func acked(stats gbn.ConnStats) uint64 {
+	return stats.PacketsSent - stats.Retransmissions - uint64(stats.InFlight)
+}
+
+// report prints result as text or JSON
+func report(result Result, opts options) {
+	if opts.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			log.Fatalln("Failed to encode result:", err)
+		}
+		return
+	}
+	fmt.Printf("\n%s over %s, window %d, max seq no. %d, payload %d bytes\n",
+		result.Mode, result.Network, result.WindowSize, result.MaxSeqNo, result.PayloadSize)
+	fmt.Printf("sent %d bytes in %v\n", result.Bytes, result.Duration.Round(time.Millisecond))
+	fmt.Printf("goodput     %s\n", formatBits(result.Goodput))
+	fmt.Printf("throughput  %s\n", formatBits(result.Throughput))
+	fmt.Printf("retransmit  %d of %d packets (%.2f%%), %d timeouts, %d dup acks\n",
+		result.Retransmissions, result.PacketsSent, result.RetransmissionRatio*100, result.Timeouts, result.DupAcks)
+	fmt.Printf("rtt         min %v, p50 %v, p90 %v, p99 %v, max %v\n",
+		result.RTT.Min, result.RTT.P50, result.RTT.P90, result.RTT.P99, result.RTT.Max)
+	fmt.Printf("window      %.1f%% of window size and %.1f%% of send window in flight, mean cwnd %.1f\n",
+		result.WindowUtilisation*100, result.SendWindowUtilisation*100, result.MeanCongestionWindow)
+}
+
+// percentiles obtains the nearest-rank percentiles of samples
+func percentiles(samples []time.Duration) Percentiles {
+	if len(samples) == 0 {
+		return Percentiles{}
+	}
+	slices.Sort(samples)
+	rank := func(p float64) time.Duration {
+		return samples[int(math.Ceil(p*float64(len(samples))))-1]
+	}
+	return Percentiles{Min: samples[0], P50: rank(0.5), P90: rank(0.9), P99: rank(0.99), Max: samples[len(samples)-1]}
+}
+
+// mean obtains the arithmetic mean of samples
+func mean(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, s := range samples {
+		sum += s
+	}
+	return sum / float64(len(samples))
+}
+
+// formatBits formats a rate in bits per second with a decimal unit
+func formatBits(bps float64) string {
+	switch {
+	case bps >= 1e9:
+		return fmt.Sprintf("%.2f Gbit/s", bps/1e9)
+	case bps >= 1e6:
+		return fmt.Sprintf("%.2f Mbit/s", bps/1e6)
+	case bps >= 1e3:
+		return fmt.Sprintf("%.2f kbit/s", bps/1e3)
+	}
+	return fmt.Sprintf("%.0f bit/s", bps)
+}
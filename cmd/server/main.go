@@ -1,31 +1,111 @@
 package main
 
 import (
+	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"rdt/internal/gbn"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"rdt"
+	"rdt/internal/config"
+	"rdt/internal/logging"
+	"rdt/internal/metrics"
+	"rdt/internal/transfer"
+	"strconv"
 )
 
 func main() {
+	metricsAddr := flag.String("metrics-addr", "", "address to serve metrics on over HTTP, such as :9090, disabled if empty")
+	receiveDir := flag.String("receive-dir", "", "directory to store files sent by clients in, refusing files if empty")
+	logFlags := logging.RegisterFlags(flag.CommandLine)
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalln("Failed to load config:", err)
+	}
+	logger, level, err := logFlags.Logger(os.Stderr)
+	if err != nil {
+		log.Fatalln("Failed to set up logging:", err)
+	}
+	slog.SetDefault(logger)
+	logging.ToggleTraceOnSignal(logger, level)
+	cfg.Logger = logger
 
 	fmt.Println("Press <Enter> to stop...")
-	// close done channel when user presses <Enter>
-	done := make(chan struct{})
+
+	listener, err := rdt.ListenConfig(net.JoinHostPort("", strconv.Itoa(int(cfg.Port))), cfg)
+	if err != nil {
+		log.Fatalln("Failed to listen:", err)
+	}
+	if *metricsAddr != "" {
+		// serve Prometheus metrics at /metrics and expvar JSON at /debug/vars
+		go func() {
+			handler := metrics.Handler(listener.(*rdt.Listener).Stats)
+			log.Fatalln("Failed to serve metrics:", http.ListenAndServe(*metricsAddr, handler))
+		}()
+	}
+	var receiver *transfer.Receiver
+	if *receiveDir != "" {
+		if err := os.MkdirAll(*receiveDir, 0o755); err != nil {
+			log.Fatalln("Failed to create receive directory:", err)
+		}
+		receiver = transfer.NewReceiver(*receiveDir)
+	}
+	// close listener when user presses <Enter>
 	go func() {
 		_, _ = fmt.Scanln()
-		close(done)
+		_ = listener.Close()
 	}()
 
-	transport := gbn.NewServerTransport()
-	transport.Start()
-	defer transport.Stop()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Fatalln("Failed to accept:", err)
+			}
+			return
+		}
+		go serveConn(conn, receiver)
+	}
+}
 
+// serveConn stores a file sent on conn with receiver, if not nil,
+// or prints everything received on conn to the screen
+func serveConn(conn net.Conn, receiver *transfer.Receiver) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if transfer.IsTransfer(r) {
+		receiveFile(conn, r, receiver)
+		return
+	}
+	buf := make([]byte, 4096)
 	for {
-		select {
-		case <-done:
+		n, err := r.Read(buf)
+		if err != nil {
 			return
-		case r := <-transport.OutputChan():
-			log.Printf("screen: %c\n", r)
 		}
+		fmt.Printf("%v: %q\n", conn.RemoteAddr(), buf[:n])
+	}
+}
+
+// receiveFile receives the file sent on conn, which r reads from, showing its progress
+func receiveFile(conn net.Conn, r *bufio.Reader, receiver *transfer.Receiver) {
+	logger := slog.With("peer", conn.RemoteAddr())
+	if receiver == nil {
+		logger.Warn("refused file without -receive-dir")
+		_ = transfer.Refuse(conn, "server does not accept files")
+		return
+	}
+	var meter transfer.Meter
+	path, err := receiver.Receive(conn, r, func(done, total int64) {
+		logger.Info("receiving file", "progress", meter.Format(done, total))
+	})
+	if err != nil {
+		logger.Error("failed to receive file", "err", err)
+		return
 	}
+	fmt.Printf("%v: received %s\n", conn.RemoteAddr(), path)
 }
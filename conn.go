@@ -0,0 +1,182 @@
+package rdt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"rdt/internal/gbn"
+	"rdt/internal/message"
+	"sync"
+	"time"
+)
+
+// Conn implements net.Conn on top of a gbn.Conn
+type Conn struct {
+	conn          *gbn.Conn
+	localAddr     netip.AddrPort
+	readDeadline  *deadline
+	writeDeadline *deadline
+	// pending holds the unread remainder of the last received payload
+	readMu  sync.Mutex
+	pending []byte
+	// close and abort also release resources owned by the connection, such as a dialed transport
+	closeTimeout time.Duration
+	closeOnce    sync.Once
+	closeErr     error
+	close        func(ctx context.Context) error
+	abort        func()
+}
+
+func newConn(conn *gbn.Conn, localAddr netip.AddrPort, closeTimeout time.Duration, close func(ctx context.Context) error, abort func()) *Conn {
+	return &Conn{
+		conn:          conn,
+		localAddr:     localAddr,
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+		closeTimeout:  closeTimeout,
+		close:         close,
+		abort:         abort,
+	}
+}
+
+// Read reads data received from the peer into b
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if len(c.pending) == 0 {
+		// prefer delivering data already received over reporting closure
+		select {
+		case p := <-c.conn.OutputChan():
+			c.pending = p
+		default:
+		}
+	}
+	if len(c.pending) == 0 {
+		select {
+		case <-c.conn.Done():
+			// data may have arrived while waiting, and select picks among ready cases at random
+			select {
+			case p := <-c.conn.OutputChan():
+				c.pending = p
+			default:
+				if errors.Is(c.conn.Err(), gbn.ErrPeerClosed) {
+					return 0, io.EOF
+				}
+				return 0, c.opError("read", c.closedErr())
+			}
+		case <-c.readDeadline.wait():
+			return 0, c.opError("read", os.ErrDeadlineExceeded)
+		case p := <-c.conn.OutputChan():
+			c.pending = p
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write sends b to the peer, split into payloads of at most message.MaxPayloadSize bytes
+func (c *Conn) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		size := min(len(b)-n, message.MaxPayloadSize)
+		// copy payload since the caller may reuse b
+		p := append([]byte(nil), b[n:n+size]...)
+		if err := c.conn.SendUntil(c.writeDeadline.wait(), p); err != nil {
+			if errors.Is(err, gbn.ErrSendCanceled) {
+				return n, c.opError("write", os.ErrDeadlineExceeded)
+			}
+			return n, c.opError("write", c.closedErr())
+		}
+		n += size
+	}
+	return n, nil
+}
+
+// Close closes the connection once all data written so far is acknowledged by the peer,
+// aborting it if that takes longer than Config.CloseTimeout
+func (c *Conn) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.closeTimeout)
+	defer cancel()
+	return c.CloseContext(ctx)
+}
+
+// CloseContext closes the connection once all data written so far is acknowledged by the peer,
+// aborting it if ctx expires first
+func (c *Conn) CloseContext(ctx context.Context) error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.close(ctx)
+	})
+	if c.closeErr != nil {
+		return c.opError("close", c.closeErr)
+	}
+	return nil
+}
+
+// Abort closes the connection immediately without notifying the peer,
+// discarding data that is not yet acknowledged
+func (c *Conn) Abort() error {
+	c.abort()
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return Addr{c.localAddr}
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return Addr{c.conn.RemoteAddr()}
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// closedErr obtains the error reported by operations on the closed connection,
+// which is net.ErrClosed unless the connection failed for another reason
+func (c *Conn) closedErr() error {
+	err := c.conn.Err()
+	if err == nil || errors.Is(err, gbn.ErrClosed) || errors.Is(err, gbn.ErrTransportClosed) {
+		return net.ErrClosed
+	}
+	return err
+}
+
+func (c *Conn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: network, Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: err}
+}
+
+// Stats obtains a snapshot of the counters and gauges of the connection
+func (c *Conn) Stats() ConnStats {
+	return c.conn.Stats()
+}
+
+// RTO obtains the current retransmission timeout of the connection
+func (c *Conn) RTO() time.Duration {
+	return c.conn.RTO()
+}
+
+// RTT obtains the smoothed round trip time and its variation
+func (c *Conn) RTT() (srtt, rttvar time.Duration) {
+	return c.conn.SRTT(), c.conn.RTTVar()
+}
+
+// CongestionWindow obtains the congestion window and slow start threshold in messages
+func (c *Conn) CongestionWindow() (cwnd, ssthresh uint32) {
+	return c.conn.CongestionWindow(), c.conn.SSThresh()
+}
@@ -0,0 +1,107 @@
+-- Wireshark dissector for the binary wire format of RDT, see internal/message/binary.go.
+-- Install by copying into the personal Lua plugins directory shown under
+-- Help > About Wireshark > Folders, or load it once with: wireshark -X lua_script:rdt.lua capture.pcapng
+-- Datagrams on UDP port 8080 are decoded as RDT, as are datagrams on other ports whose header is valid.
+-- Datagrams sealed with a pre-shared key or encoded with the text codec are not decoded.
+
+local rdt = Proto("rdt", "Reliable Data Transfer")
+
+local HEADER_SIZE = 15
+local MAGIC = 0xA
+local VERSION = 3
+local FLAG_SELECTIVE = 0x01
+
+local types = {
+    [1] = "DATA",
+    [2] = "ACK",
+    [3] = "SYN",
+    [4] = "SYNACK",
+    [5] = "FIN",
+    [6] = "FINACK",
+    [7] = "KEEPALIVE",
+    [8] = "KEEPALIVEACK",
+    [9] = "COOKIE",
+}
+
+local f = rdt.fields
+f.magic = ProtoField.uint8("rdt.magic", "Magic", base.HEX, nil, 0xF0)
+f.version = ProtoField.uint8("rdt.version", "Version", base.DEC, nil, 0x0F)
+f.type = ProtoField.uint8("rdt.type", "Type", base.DEC, types)
+f.flags = ProtoField.uint8("rdt.flags", "Flags", base.HEX)
+f.selective = ProtoField.bool("rdt.flags.selective", "Selective", 8, nil, FLAG_SELECTIVE)
+f.seq = ProtoField.uint32("rdt.seq", "Sequence number", base.DEC)
+f.window = ProtoField.uint16("rdt.window", "Window", base.DEC)
+f.length = ProtoField.uint16("rdt.length", "Payload length", base.DEC)
+f.checksum = ProtoField.uint32("rdt.checksum", "Checksum", base.HEX)
+f.maxseq = ProtoField.uint32("rdt.maxseq", "Sequence number space", base.DEC)
+f.cookie = ProtoField.uint32("rdt.cookie", "Cookie", base.HEX)
+f.payload = ProtoField.bytes("rdt.payload", "Payload")
+
+-- valid reports whether buf starts with an RDT header that matches its length
+local function valid(buf)
+    if buf:len() < HEADER_SIZE then
+        return false
+    end
+    local first = buf(0, 1):uint()
+    if bit.rshift(first, 4) ~= MAGIC or bit.band(first, 0x0F) ~= VERSION then
+        return false
+    end
+    if types[buf(1, 1):uint()] == nil then
+        return false
+    end
+    return buf:len() == HEADER_SIZE + buf(9, 2):uint()
+end
+
+function rdt.dissector(buf, pinfo, tree)
+    if not valid(buf) then
+        return 0
+    end
+    pinfo.cols.protocol = "RDT"
+    local msgType = buf(1, 1):uint()
+    local name = types[msgType]
+    local length = buf(9, 2):uint()
+
+    local subtree = tree:add(rdt, buf(), "Reliable Data Transfer, " .. name)
+    subtree:add(f.magic, buf(0, 1))
+    subtree:add(f.version, buf(0, 1))
+    subtree:add(f.type, buf(1, 1))
+    local flags = subtree:add(f.flags, buf(2, 1))
+    flags:add(f.selective, buf(2, 1))
+    subtree:add(f.seq, buf(3, 4))
+    subtree:add(f.window, buf(7, 2))
+    subtree:add(f.length, buf(9, 2))
+    subtree:add(f.checksum, buf(11, 4))
+
+    local info = string.format("%s seq=%d win=%d", name, buf(3, 4):uint(), buf(7, 2):uint())
+    if bit.band(buf(2, 1):uint(), FLAG_SELECTIVE) ~= 0 then
+        info = info .. " selective"
+    end
+    if length > 0 then
+        local payload = buf(HEADER_SIZE, length)
+        if (msgType == 3 or msgType == 4) and length >= 4 then
+            -- offered sequence no. space, followed by the cookie of a SYN echoing one
+            subtree:add(f.maxseq, payload(0, 4))
+            info = info .. " maxseq=" .. payload(0, 4):uint()
+            if length == 8 then
+                subtree:add(f.cookie, payload(4, 4))
+                info = info .. string.format(" cookie=0x%08x", payload(4, 4):uint())
+            end
+        else
+            subtree:add(f.payload, payload)
+            info = info .. " len=" .. length
+        end
+    end
+    pinfo.cols.info = info
+    return buf:len()
+end
+
+local function heuristic(buf, pinfo, tree)
+    if not valid(buf) then
+        return false
+    end
+    rdt.dissector(buf, pinfo, tree)
+    return true
+end
+
+DissectorTable.get("udp.port"):add(8080, rdt)
+rdt:register_heuristic("udp", heuristic)
@@ -0,0 +1,54 @@
+package clock
+
+import "time"
+
+// Clock is the source of time and timers for protocol timing,
+// so that timeouts can be driven by a Fake clock instead of real time
+type Clock interface {
+	// Now obtains the current time
+	Now() time.Time
+	// NewTimer creates a timer that sends the current time on its channel after d
+	NewTimer(d time.Duration) Timer
+	// AfterFunc creates a timer that calls f in its own goroutine after d
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a single event created by a Clock, behaving like time.Timer
+type Timer interface {
+	// C obtains the channel the time is sent on, which is nil for timers created by AfterFunc
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, returning false if it already fired or was stopped
+	Stop() bool
+	// Reset changes the timer to fire after d, returning whether it was still active
+	Reset(d time.Duration) bool
+}
+
+// Since obtains the time elapsed on c since t
+func Since(c Clock, t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Real is the clock of the operating system
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
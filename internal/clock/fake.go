@@ -0,0 +1,119 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a clock that only moves when advanced, firing due timers in order of their deadlines
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]struct{} // active timers
+}
+
+// NewFake creates a fake clock starting at start
+func NewFake(start time.Time) *Fake {
+	return &Fake{
+		now:    start,
+		timers: make(map[*fakeTimer]struct{}),
+	}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	t := &fakeTimer{clock: f, fn: fn}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d, firing all timers that become due on the way
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	end := f.now.Add(d)
+	for {
+		// find the earliest due timer
+		var next *fakeTimer
+		for t := range f.timers {
+			if !t.when.After(end) && (next == nil || t.when.Before(next.when)) {
+				next = t
+			}
+		}
+		if next == nil {
+			break
+		}
+		if next.when.After(f.now) {
+			f.now = next.when
+		}
+		f.fire(next)
+	}
+	f.now = end
+}
+
+// Pending obtains the number of active timers, which lets callers wait
+// until the code under test has armed the timers it is expected to
+func (f *Fake) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// fire deactivates t and delivers its event. It must be called with f.mu held.
+func (f *Fake) fire(t *fakeTimer) {
+	delete(f.timers, t)
+	if t.fn != nil {
+		go t.fn()
+		return
+	}
+	// like time.Timer, drop the event if the previous one was not received
+	select {
+	case t.c <- f.now:
+	default:
+	}
+}
+
+type fakeTimer struct {
+	clock *Fake
+	when  time.Time
+	c     chan time.Time
+	fn    func()
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	_, active := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	f := t.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, active := f.timers[t]
+	t.when = f.now.Add(d)
+	if d <= 0 {
+		delete(f.timers, t)
+		f.fire(t)
+		return active
+	}
+	f.timers[t] = struct{}{}
+	return active
+}
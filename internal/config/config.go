@@ -0,0 +1,148 @@
+// Package config fills a gbn.Config from optional sources:
+// a config file, environment variables and command line flags.
+package config
+
+import (
+	"fmt"
+	"rdt/internal/gbn"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// option is a setting that can be given by any source under the same key
+type option struct {
+	key   string                    // environment variable and config file key
+	usage string                    // description shown in the usage of flags
+	field func(cfg *gbn.Config) any // pointer to the field of cfg the option sets
+}
+
+var options = []option{
+	{"PORT", "port servers listen on and clients connect to", func(c *gbn.Config) any { return &c.Port }},
+	{"WINDOW_SIZE", "maximum number of unacknowledged messages", func(c *gbn.Config) any { return &c.WindowSize }},
+	{"MAX_SEQ_NO", "size of the sequence number space", func(c *gbn.Config) any { return &c.MaxSeqNo }},
+	{"PROTOCOL", "pipelining protocol (gbn|sr)", func(c *gbn.Config) any { return &c.Mode }},
+	{"CODEC", "wire format (binary|text)", func(c *gbn.Config) any { return &c.Codec }},
+	{"PSK", "hex-encoded pre-shared key that authenticates and encrypts every datagram, empty disables it", func(c *gbn.Config) any { return &c.PreSharedKey }},
+	{"CAPTURE_FILE", "pcapng file to record every datagram in for Wireshark, empty disables it", func(c *gbn.Config) any { return &c.CaptureFile }},
+	{"TRACE_FILE", "file to record protocol decisions in as JSON lines for rdt-trace, empty disables it", func(c *gbn.Config) any { return &c.TraceFile }},
+	{"CONGESTION_CONTROL", "congestion control algorithm (newreno|none)", func(c *gbn.Config) any { return &c.CongestionControl }},
+	{"DUP_ACK_THRESHOLD", "duplicate acks that trigger a fast retransmit, 0 disables it", func(c *gbn.Config) any { return &c.DupAckThreshold }},
+	{"INITIAL_RTO", "retransmission timeout before the first round trip time sample", func(c *gbn.Config) any { return &c.InitialRTO }},
+	{"MIN_RTO", "lower bound of the retransmission timeout", func(c *gbn.Config) any { return &c.MinRTO }},
+	{"MAX_RTO", "upper bound of the retransmission timeout", func(c *gbn.Config) any { return &c.MaxRTO }},
+	{"HANDSHAKE_TIMEOUT", "delay before a handshake or teardown message is retransmitted", func(c *gbn.Config) any { return &c.HandshakeTimeout }},
+	{"HANDSHAKE_RETRIES", "retransmissions before a handshake or teardown is given up", func(c *gbn.Config) any { return &c.HandshakeRetries }},
+	{"TIME_WAIT", "time a closed connection lingers to acknowledge retransmitted FINs", func(c *gbn.Config) any { return &c.TimeWaitDuration }},
+	{"CLOSE_TIMEOUT", "time a graceful close waits for unacknowledged data before aborting", func(c *gbn.Config) any { return &c.CloseTimeout }},
+	{"IDLE_TIMEOUT", "time without hearing from a peer before its connection is evicted, 0 disables it", func(c *gbn.Config) any { return &c.IdleTimeout }},
+	{"KEEPALIVE_INTERVAL", "time without hearing from a peer before it is probed, 0 disables it", func(c *gbn.Config) any { return &c.KeepaliveInterval }},
+	{"MAX_PEERS", "peers a server has connections with at once, 0 means no limit", func(c *gbn.Config) any { return &c.MaxPeers }},
+	{"PEER_RATE_LIMIT", "connection requests per second a server accepts from each source IP, 0 disables it", func(c *gbn.Config) any { return &c.PeerRateLimit }},
+	{"PEER_RATE_BURST", "connection requests a source IP may send at once", func(c *gbn.Config) any { return &c.PeerRateBurst }},
+	{"SYN_COOKIES", "challenge connection requests with a cookie before allocating state (true|false)", func(c *gbn.Config) any { return &c.SynCookies }},
+	{"UDP_READ_TIMEOUT", "interval at which the UDP receiver checks whether to stop", func(c *gbn.Config) any { return &c.UDPReadTimeout }},
+	{"UDP_WRITE_TIMEOUT", "interval at which the UDP sender checks whether to stop", func(c *gbn.Config) any { return &c.UDPWriteTimeout }},
+	{"RECV_CHAN_BUFFER_SIZE", "messages received from the network", func(c *gbn.Config) any { return &c.RecvChanBufferSize }},
+	{"SEND_CHAN_BUFFER_SIZE", "messages waiting to be sent to the network", func(c *gbn.Config) any { return &c.SendChanBufferSize }},
+	{"LOCAL_SENDER_RECV_CHAN_BUFFER_SIZE", "acks waiting for the sender of a connection", func(c *gbn.Config) any { return &c.LocalSenderRecvChanBufferSize }},
+	{"LOCAL_RECEIVER_RECV_CHAN_BUFFER_SIZE", "data waiting for the receiver of a connection", func(c *gbn.Config) any { return &c.LocalReceiverRecvChanBufferSize }},
+	{"LOCAL_CONTROL_RECV_CHAN_BUFFER_SIZE", "control messages waiting for a connection", func(c *gbn.Config) any { return &c.LocalControlRecvChanBufferSize }},
+	{"INPUT_CHAN_BUFFER_SIZE", "payloads written but not yet sent", func(c *gbn.Config) any { return &c.InputChanBufferSize }},
+	{"OUTPUT_CHAN_BUFFER_SIZE", "payloads ready to be read", func(c *gbn.Config) any { return &c.OutputChanBufferSize }},
+	{"ACCEPT_CHAN_BUFFER_SIZE", "connections established but not yet accepted", func(c *gbn.Config) any { return &c.AcceptChanBufferSize }},
+	{"RECEIVE_BUFFER_SIZE", "in-order payloads a receiver buffers", func(c *gbn.Config) any { return &c.ReceiveBufferSize }},
+	{"UDP_RECV_BUFFER_SIZE", "largest datagram that can be received in bytes", func(c *gbn.Config) any { return &c.UDPRecvBufferSize }},
+}
+
+// apply sets every option that lookup finds a value for
+func apply(cfg *gbn.Config, source string, lookup func(key string) (string, bool)) error {
+	for _, opt := range options {
+		value, ok := lookup(opt.key)
+		if !ok {
+			continue
+		}
+		if err := set(opt.field(cfg), value); err != nil {
+			return fmt.Errorf("%s %s: %w", source, opt.key, err)
+		}
+	}
+	return nil
+}
+
+// set parses value into the field pointed to by field
+func set(field any, value string) error {
+	value = strings.TrimSpace(value)
+	switch f := field.(type) {
+	case *uint16:
+		n, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return err
+		}
+		*f = uint16(n)
+	case *uint32:
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return err
+		}
+		*f = uint32(n)
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*f = n
+	case *float64:
+		x, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*f = x
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*f = b
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*f = d
+	case *string:
+		*f = value
+	case *gbn.Mode:
+		mode, err := gbn.ParseMode(value)
+		if err != nil {
+			return err
+		}
+		*f = mode
+	default:
+		panic(fmt.Sprintf("config: unsupported field type %T", field))
+	}
+	return nil
+}
+
+// format formats the value of the field pointed to by field
+func format(field any) string {
+	switch f := field.(type) {
+	case *uint16:
+		return strconv.FormatUint(uint64(*f), 10)
+	case *uint32:
+		return strconv.FormatUint(uint64(*f), 10)
+	case *int:
+		return strconv.Itoa(*f)
+	case *float64:
+		return strconv.FormatFloat(*f, 'g', -1, 64)
+	case *bool:
+		return strconv.FormatBool(*f)
+	case *time.Duration:
+		return f.String()
+	case *string:
+		return *f
+	case *gbn.Mode:
+		return f.String()
+	default:
+		panic(fmt.Sprintf("config: unsupported field type %T", field))
+	}
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"rdt/internal/gbn"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes a config file holding lines, returning its path
+func writeFile(t *testing.T, lines string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rdt.conf")
+	if err := os.WriteFile(path, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// load builds a config from args like the commands do
+func load(args ...string) (gbn.Config, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return Load(fs, args)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `
+# set by every source
+WINDOW_SIZE=10
+MAX_SEQ_NO=100
+MIN_RTO=50ms
+PROTOCOL=sr
+`)
+	t.Setenv("WINDOW_SIZE", "20")
+	t.Setenv("MAX_SEQ_NO", "200")
+	cfg, err := load("-config", path, "-window-size", "30")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaults := gbn.DefaultConfig()
+	for _, tc := range []struct {
+		name      string
+		got, want any
+	}{
+		{"flag over environment and file", cfg.WindowSize, uint32(30)},
+		{"environment over file", cfg.MaxSeqNo, uint32(200)},
+		{"file over default", cfg.MinRTO, 50 * time.Millisecond},
+		{"file over default", cfg.Mode, gbn.SelectiveRepeat},
+		{"default", cfg.MaxRTO, defaults.MaxRTO},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestLoadReportsMalformedValues(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		file string
+		env  map[string]string
+		want string // error message, where FILE stands for the path of the file
+	}{
+		{
+			name: "file value",
+			file: "WINDOW_SIZE=ten\n",
+			want: `FILE WINDOW_SIZE: strconv.ParseUint: parsing "ten": invalid syntax`,
+		},
+		{
+			name: "file line",
+			file: "WINDOW_SIZE=10\nten\n",
+			want: "FILE:2: expected KEY=value",
+		},
+		{
+			name: "environment variable",
+			env:  map[string]string{"PROTOCOL": "tcp"},
+			want: `environment variable PROTOCOL: unknown protocol mode "tcp"`,
+		},
+		{
+			name: "environment variable over valid file",
+			file: "MIN_RTO=50ms\n",
+			env:  map[string]string{"MIN_RTO": "50"},
+			want: `environment variable MIN_RTO: time: missing unit in duration "50"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+			var args []string
+			want := tc.want
+			if tc.file != "" {
+				path := writeFile(t, tc.file)
+				args = append(args, "-config", path)
+				want = strings.ReplaceAll(want, "FILE", path)
+			}
+			_, err := load(args...)
+			if err == nil || err.Error() != want {
+				t.Fatalf("failed with %v, want %s", err, want)
+			}
+		})
+	}
+}
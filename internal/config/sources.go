@@ -0,0 +1,110 @@
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"rdt/internal/gbn"
+	"strings"
+)
+
+// FromEnv sets the options given as environment variables
+func FromEnv(cfg *gbn.Config) error {
+	return apply(cfg, "environment variable", os.LookupEnv)
+}
+
+// FromFile sets the options given in the file at path,
+// which holds one KEY=value pair per line using the environment variable names.
+// Empty lines and lines starting with # are ignored.
+func FromFile(cfg *gbn.Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected KEY=value", path, lineNo)
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return apply(cfg, path, func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	})
+}
+
+// Flags holds the command line flags registered for every option
+type Flags struct {
+	fs         *flag.FlagSet
+	configFile *string
+}
+
+// RegisterFlags registers a flag for every option on fs, named after its environment variable
+// in lower case with dashes (e.g. -window-size), plus a -config flag naming a config file
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	defaults := gbn.DefaultConfig()
+	for _, opt := range options {
+		fs.String(flagName(opt.key), format(opt.field(&defaults)), opt.usage)
+	}
+	return &Flags{
+		fs:         fs,
+		configFile: fs.String("config", "", "file of KEY=value options"),
+	}
+}
+
+// Apply sets the options given as flags that were explicitly set on the command line
+func (f *Flags) Apply(cfg *gbn.Config) error {
+	values := make(map[string]string)
+	f.fs.Visit(func(fl *flag.Flag) {
+		values[fl.Name] = fl.Value.String()
+	})
+	return apply(cfg, "flag", func(key string) (string, bool) {
+		value, ok := values[flagName(key)]
+		return value, ok
+	})
+}
+
+// ConfigFile obtains the path given with -config, or an empty string
+func (f *Flags) ConfigFile() string {
+	return *f.configFile
+}
+
+// Load parses args with flags registered on fs and builds a validated config from the defaults,
+// overridden by the config file, then by environment variables and finally by flags
+func Load(fs *flag.FlagSet, args []string) (gbn.Config, error) {
+	flags := RegisterFlags(fs)
+	cfg := gbn.DefaultConfig()
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if path := flags.ConfigFile(); path != "" {
+		if err := FromFile(&cfg, path); err != nil {
+			return cfg, err
+		}
+	}
+	if err := FromEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	if err := flags.Apply(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// flagName converts an environment variable name into a flag name
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
@@ -0,0 +1,190 @@
+package gbn
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"rdt/internal/clock"
+	"rdt/internal/message"
+	"rdt/internal/seal"
+	"time"
+)
+
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Config holds the settings of a transport and all of its connections
+type Config struct {
+	// protocol
+	Port              uint16 // port servers listen on and clients connect to
+	WindowSize        uint32 // maximum number of unacked messages, agreed as the minimum of both peers
+	MaxSeqNo          uint32 // size of the sequence no. space
+	Mode              Mode   // pipelining protocol, which both peers must agree on
+	Codec             string // name of the wire format, see message.ParseCodec
+	PreSharedKey      string // hex-encoded key that seals every datagram, where an empty key disables sealing
+	CaptureFile       string // pcapng file that every datagram is recorded in, where an empty name disables capture
+	TraceFile         string // file that protocol decisions are recorded in as JSON lines, where an empty name disables tracing
+	CongestionControl string // name of the congestion control algorithm, see ParseCongestionControl
+	DupAckThreshold   int    // duplicate acks, or acks of later messages in Selective Repeat, that trigger a fast retransmit, where 0 disables it
+	// timeouts
+	InitialRTO        time.Duration // retransmission timeout before the first round trip time sample
+	MinRTO            time.Duration // lower bound of the retransmission timeout
+	MaxRTO            time.Duration // upper bound of the retransmission timeout
+	HandshakeTimeout  time.Duration // delay before a handshake or teardown message is retransmitted
+	HandshakeRetries  int           // retransmissions before a handshake or teardown is given up
+	TimeWaitDuration  time.Duration // time a closed connection lingers to acknowledge retransmitted FINs
+	CloseTimeout      time.Duration // time a graceful close without a deadline waits for unacked data before aborting
+	IdleTimeout       time.Duration // time without hearing from a peer before its connection is evicted, where 0 disables it
+	KeepaliveInterval time.Duration // time without hearing from a peer before it is probed, where 0 disables it
+	// admission of peers by servers
+	MaxPeers        int           // peers a server has connections with at once, where 0 means no limit
+	PeerRateLimit   float64       // connection requests per second a server accepts from each source IP, where 0 disables it
+	PeerRateBurst   int           // connection requests a source IP may send at once before it is rate limited
+	SynCookies      bool          // whether a server challenges connection requests with a cookie before allocating state
+	UDPReadTimeout  time.Duration // interval at which the UDP receiver checks whether to stop
+	UDPWriteTimeout time.Duration // interval at which the UDP sender checks whether to stop
+	// buffer sizes
+	RecvChanBufferSize              int // messages received from the network
+	SendChanBufferSize              int // messages waiting to be sent to the network
+	LocalSenderRecvChanBufferSize   int // acks waiting for the sender of a connection
+	LocalReceiverRecvChanBufferSize int // data waiting for the receiver of a connection
+	LocalControlRecvChanBufferSize  int // control messages waiting for a connection
+	InputChanBufferSize             int // payloads written by the user but not yet sent
+	OutputChanBufferSize            int // payloads ready to be read by the user
+	AcceptChanBufferSize            int // connections established but not yet accepted
+	ReceiveBufferSize               int // in-order payloads a receiver buffers, advertised as its receive window
+	UDPRecvBufferSize               int // largest datagram that can be received in bytes
+	// Clock drives all protocol timing, defaulting to clock.Real
+	Clock clock.Clock
+	// Logger receives lifecycle events at info level, failures at warn and error level
+	// and packet traces at debug level, defaulting to slog.Default() when the transport is created.
+	// Tracing is switched at runtime by changing the level of its handler, such as with a slog.LevelVar.
+	Logger *slog.Logger
+	// OnRTTSample is called with every round trip time measured on a connection with peer if not nil,
+	// which excludes acks of retransmitted messages (Karn's rule). It is called by protocol goroutines, so it must not block.
+	OnRTTSample func(peer netip.AddrPort, rtt time.Duration)
+}
+
+// DefaultConfig obtains the default settings
+func DefaultConfig() Config {
+	return Config{
+		Port:                            8080,
+		WindowSize:                      32,
+		MaxSeqNo:                        256,
+		Mode:                            GoBackN,
+		Codec:                           "binary",
+		CongestionControl:               "newreno",
+		DupAckThreshold:                 3,
+		InitialRTO:                      time.Second,
+		MinRTO:                          200 * time.Millisecond,
+		MaxRTO:                          60 * time.Second,
+		HandshakeTimeout:                time.Second,
+		HandshakeRetries:                5,
+		TimeWaitDuration:                2 * time.Second,
+		CloseTimeout:                    10 * time.Second,
+		IdleTimeout:                     60 * time.Second,
+		KeepaliveInterval:               15 * time.Second,
+		MaxPeers:                        1024,
+		PeerRateLimit:                   5,
+		PeerRateBurst:                   10,
+		SynCookies:                      true,
+		UDPReadTimeout:                  time.Second,
+		UDPWriteTimeout:                 time.Second,
+		RecvChanBufferSize:              64,
+		SendChanBufferSize:              64,
+		LocalSenderRecvChanBufferSize:   8,
+		LocalReceiverRecvChanBufferSize: 8,
+		LocalControlRecvChanBufferSize:  4,
+		InputChanBufferSize:             8,
+		OutputChanBufferSize:            4,
+		AcceptChanBufferSize:            8,
+		ReceiveBufferSize:               32,
+		UDPRecvBufferSize:               8192,
+		Clock:                           clock.Real,
+	}
+}
+
+// Validate checks that the settings are consistent, reporting the first violation.
+// In particular the window must be small enough for the sequence no. space
+// that a receiver can tell a new window from a retransmitted one.
+func (cfg *Config) Validate() error {
+	switch {
+	case cfg.MaxSeqNo < 2 || cfg.MaxSeqNo > 1<<31:
+		return invalid("max sequence no. %d must be within [2, %d]", cfg.MaxSeqNo, uint32(1<<31))
+	case cfg.WindowSize == 0 || cfg.WindowSize > 0xFFFF:
+		return invalid("window size %d must be within [1, %d]", cfg.WindowSize, 0xFFFF)
+	case cfg.Mode == GoBackN && cfg.WindowSize >= cfg.MaxSeqNo:
+		return invalid("window size %d must be smaller than max sequence no. %d for %v", cfg.WindowSize, cfg.MaxSeqNo, cfg.Mode)
+	case cfg.Mode == SelectiveRepeat && cfg.WindowSize > cfg.MaxSeqNo/2:
+		return invalid("window size %d must be at most half of max sequence no. %d for %v", cfg.WindowSize, cfg.MaxSeqNo, cfg.Mode)
+	case cfg.Mode != GoBackN && cfg.Mode != SelectiveRepeat:
+		return invalid("unknown mode %v", cfg.Mode)
+	case cfg.MaxPeers < 0:
+		return invalid("max peers %d must not be negative", cfg.MaxPeers)
+	case cfg.PeerRateLimit < 0 || (cfg.PeerRateLimit > 0 && cfg.PeerRateBurst < 1):
+		return invalid("peer rate limit %v must not be negative and its burst %d must be at least 1", cfg.PeerRateLimit, cfg.PeerRateBurst)
+	case cfg.DupAckThreshold < 0:
+		return invalid("duplicate ack threshold %d must not be negative", cfg.DupAckThreshold)
+	case cfg.MinRTO <= 0 || cfg.MaxRTO < cfg.MinRTO:
+		return invalid("retransmission timeout bounds [%v, %v] must be positive and ordered", cfg.MinRTO, cfg.MaxRTO)
+	case cfg.InitialRTO <= 0 || cfg.HandshakeTimeout <= 0 || cfg.UDPReadTimeout <= 0 || cfg.UDPWriteTimeout <= 0 || cfg.CloseTimeout <= 0:
+		return invalid("timeouts must be positive")
+	case cfg.HandshakeRetries < 0 || cfg.TimeWaitDuration < 0:
+		return invalid("handshake retries and time wait duration must not be negative")
+	case cfg.IdleTimeout < 0 || cfg.KeepaliveInterval < 0:
+		return invalid("idle timeout and keepalive interval must not be negative")
+	case cfg.IdleTimeout > 0 && cfg.KeepaliveInterval >= cfg.IdleTimeout:
+		return invalid("keepalive interval %v must be shorter than idle timeout %v", cfg.KeepaliveInterval, cfg.IdleTimeout)
+	case cfg.RecvChanBufferSize < 0 || cfg.SendChanBufferSize < 0 ||
+		cfg.LocalSenderRecvChanBufferSize < 0 || cfg.LocalReceiverRecvChanBufferSize < 0 ||
+		cfg.LocalControlRecvChanBufferSize < 0 || cfg.InputChanBufferSize < 0 ||
+		cfg.OutputChanBufferSize < 0 || cfg.AcceptChanBufferSize < 0:
+		return invalid("channel buffer sizes must not be negative")
+	case cfg.ReceiveBufferSize < 1:
+		return invalid("receive buffer size %d must be at least 1", cfg.ReceiveBufferSize)
+	case cfg.UDPRecvBufferSize < cfg.maxDatagramSize():
+		return invalid("UDP receive buffer size %d must fit a full message of %d bytes",
+			cfg.UDPRecvBufferSize, cfg.maxDatagramSize())
+	}
+	if cfg.PreSharedKey != "" {
+		if _, err := seal.ParseKey(cfg.PreSharedKey); err != nil {
+			return invalid("%v", err)
+		}
+	}
+	return nil
+}
+
+// maxDatagramSize obtains the size of the largest datagram sent with the settings
+func (cfg *Config) maxDatagramSize() int {
+	size := message.HeaderSize + message.MaxPayloadSize
+	if cfg.Codec == "text" {
+		size = message.MaxTextSize
+	}
+	if cfg.PreSharedKey != "" {
+		size += seal.Overhead
+	}
+	return size
+}
+
+// offer fills in the settings that both ends of a connection must share in msg, a SYN or SYNACK
+func (cfg *Config) offer(msg *message.AddressedMessage) {
+	msg.MaxSeqNo = cfg.MaxSeqNo
+	msg.Selective = cfg.Mode == SelectiveRepeat
+}
+
+// checkOffer fails with ErrIncompatiblePeer unless the settings offered in msg, a SYN or SYNACK, match those of cfg
+func (cfg *Config) checkOffer(msg *message.AddressedMessage) error {
+	mode := GoBackN
+	if msg.Selective {
+		mode = SelectiveRepeat
+	}
+	if mode != cfg.Mode || msg.MaxSeqNo != cfg.MaxSeqNo {
+		return fmt.Errorf("%w: peer uses protocol %s with max seq no. %d, but %s with %d is configured",
+			ErrIncompatiblePeer, mode, msg.MaxSeqNo, cfg.Mode, cfg.MaxSeqNo)
+	}
+	return nil
+}
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidConfig, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,48 @@
+package gbn
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateWindowLimits(t *testing.T) {
+	for _, tc := range []struct {
+		mode     Mode
+		window   uint32
+		maxSeqNo uint32
+		valid    bool
+	}{
+		// Go-Back-N needs one sequence no. more than the window
+		{GoBackN, 7, 8, true},
+		{GoBackN, 8, 8, false},
+		{GoBackN, 1, 2, true},
+		{GoBackN, 2, 2, false},
+		{GoBackN, 9, 10, true},
+		{GoBackN, 0xFFFF, 1 << 31, true},
+		{GoBackN, 0xFFFF + 1, 1 << 31, false},
+		// Selective Repeat needs twice the window
+		{SelectiveRepeat, 4, 8, true},
+		{SelectiveRepeat, 5, 8, false},
+		{SelectiveRepeat, 1, 2, true},
+		{SelectiveRepeat, 2, 2, false},
+		{SelectiveRepeat, 4, 9, true},
+		{SelectiveRepeat, 5, 9, false},
+		// bounds shared by both modes
+		{GoBackN, 0, 8, false},
+		{SelectiveRepeat, 0, 8, false},
+		{GoBackN, 1, 1, false},
+		{SelectiveRepeat, 1, 1<<31 + 1, false},
+	} {
+		cfg := DefaultConfig()
+		cfg.Mode = tc.mode
+		cfg.WindowSize = tc.window
+		cfg.MaxSeqNo = tc.maxSeqNo
+		err := cfg.Validate()
+		if valid := err == nil; valid != tc.valid {
+			t.Errorf("%v with window %d and max seq no. %d: valid %t, want %t (%v)", tc.mode, tc.window, tc.maxSeqNo, valid, tc.valid, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%v with window %d and max seq no. %d: failed with %v, want %v", tc.mode, tc.window, tc.maxSeqNo, err, ErrInvalidConfig)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package gbn
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// CongestionController decides how many messages a sender may have in flight.
+// Its methods are called by the sender goroutine, while Window and SSThresh
+// may also be called concurrently to report the current state.
+type CongestionController interface {
+	// Window obtains the congestion window in messages
+	Window() uint32
+	// SSThresh obtains the slow start threshold in messages
+	SSThresh() uint32
+	// OnAck is called when acked messages are newly acknowledged outside of loss recovery,
+	// with inFlight messages in flight before the ack and window the most the sender may ever have in flight
+	OnAck(acked, inFlight, window uint32)
+	// OnLoss is called once per loss event detected without a timeout
+	OnLoss(inFlight uint32)
+	// OnTimeout is called when the retransmission timer expires
+	OnTimeout(inFlight uint32)
+}
+
+// ParseCongestionControl parses the name of a congestion control algorithm
+// into a constructor for its controllers
+func ParseCongestionControl(name string) (func() CongestionController, error) {
+	switch name {
+	case "newreno":
+		return func() CongestionController { return NewNewReno(initialCwnd) }, nil
+	case "none":
+		return func() CongestionController { return FixedWindow{} }, nil
+	default:
+		return nil, fmt.Errorf("unknown congestion control %q", name)
+	}
+}
+
+// initialCwnd is the congestion window of a new connection in messages
+const initialCwnd = 4
+
+// NewReno implements TCP NewReno slow start and congestion avoidance
+// with the congestion window counted in messages.
+// The window only grows while the sender uses all of it and never beyond the window of the sender (RFC 7661).
+type NewReno struct {
+	mu       sync.Mutex
+	cwnd     uint32
+	ssthresh uint32
+	acked    uint32 // acks counted towards the next increase in congestion avoidance
+}
+
+// NewNewReno creates a NewReno controller starting in slow start with congestion window cwnd
+func NewNewReno(cwnd uint32) *NewReno {
+	return &NewReno{
+		cwnd:     max(cwnd, 1),
+		ssthresh: math.MaxUint32,
+	}
+}
+
+func (c *NewReno) Window() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cwnd
+}
+
+func (c *NewReno) SSThresh() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ssthresh
+}
+
+func (c *NewReno) OnAck(acked, inFlight, window uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cwnd = max(min(c.cwnd, window), 1)
+	if inFlight < c.cwnd {
+		// the window was not what limited the sender, so acks do not show that a larger one is safe
+		return
+	}
+	if c.cwnd < c.ssthresh {
+		// slow start: grow by one message per acked message
+		c.cwnd = min(addSat(c.cwnd, acked), c.ssthresh, window)
+		return
+	}
+	// congestion avoidance: grow by one message per window of acked messages
+	c.acked = addSat(c.acked, acked)
+	if c.acked >= c.cwnd {
+		c.acked -= c.cwnd
+		c.cwnd = min(addSat(c.cwnd, 1), window)
+	}
+}
+
+func (c *NewReno) OnLoss(inFlight uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ssthresh = max(inFlight/2, 2)
+	c.cwnd = c.ssthresh
+	c.acked = 0
+}
+
+func (c *NewReno) OnTimeout(inFlight uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ssthresh = max(inFlight/2, 2)
+	c.cwnd = 1
+	c.acked = 0
+}
+
+// addSat adds a and b, saturating instead of wrapping around to a small window
+func addSat(a, b uint32) uint32 {
+	if a > math.MaxUint32-b {
+		return math.MaxUint32
+	}
+	return a + b
+}
+
+// FixedWindow disables congestion control, leaving the window agreed in the handshake as the only limit
+type FixedWindow struct{}
+
+func (FixedWindow) Window() uint32               { return math.MaxUint32 }
+func (FixedWindow) SSThresh() uint32             { return math.MaxUint32 }
+func (FixedWindow) OnAck(uint32, uint32, uint32) {}
+func (FixedWindow) OnLoss(uint32)                {}
+func (FixedWindow) OnTimeout(uint32)             {}
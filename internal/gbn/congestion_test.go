@@ -0,0 +1,55 @@
+package gbn
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewRenoOnAck(t *testing.T) {
+	tests := []struct {
+		name     string
+		cwnd     uint32
+		ssthresh uint32
+		acks     int    // acks of one message each
+		inFlight uint32 // messages in flight at each ack, where 0 means the congestion window
+		window   uint32
+		want     uint32
+	}{
+		{name: "slow start", cwnd: 4, ssthresh: math.MaxUint32, acks: 4, window: 32, want: 8},
+		{name: "slow start capped at window", cwnd: 4, ssthresh: math.MaxUint32, acks: 100, window: 32, want: 32},
+		{name: "slow start capped at ssthresh", cwnd: 4, ssthresh: 6, acks: 4, window: 32, want: 6},
+		{name: "congestion avoidance", cwnd: 8, ssthresh: 8, acks: 8, window: 32, want: 9},
+		{name: "congestion avoidance capped at window", cwnd: 8, ssthresh: 8, acks: 1000, window: 10, want: 10},
+		{name: "not limited by window", cwnd: 8, ssthresh: math.MaxUint32, acks: 100, inFlight: 3, window: 32, want: 8},
+		{name: "shrinks to window", cwnd: 8, ssthresh: math.MaxUint32, acks: 1, inFlight: 1, window: 2, want: 2},
+		{name: "saturates", cwnd: math.MaxUint32 - 1, ssthresh: math.MaxUint32, acks: 3, window: math.MaxUint32, want: math.MaxUint32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewNewReno(tt.cwnd)
+			c.ssthresh = tt.ssthresh
+			for range tt.acks {
+				inFlight := tt.inFlight
+				if inFlight == 0 {
+					inFlight = c.Window()
+				}
+				c.OnAck(1, inFlight, tt.window)
+			}
+			if got := c.Window(); got != tt.want {
+				t.Fatalf("window %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRenoLoss(t *testing.T) {
+	c := NewNewReno(initialCwnd)
+	c.OnLoss(20)
+	if c.Window() != 10 || c.SSThresh() != 10 {
+		t.Fatalf("after loss window %d and ssthresh %d, want 10 and 10", c.Window(), c.SSThresh())
+	}
+	c.OnTimeout(3)
+	if c.Window() != 1 || c.SSThresh() != 2 {
+		t.Fatalf("after timeout window %d and ssthresh %d, want 1 and 2", c.Window(), c.SSThresh())
+	}
+}
@@ -0,0 +1,102 @@
+package gbn
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"time"
+)
+
+// Conn is a handle to the reliable data transfer connection with a single peer
+type Conn struct {
+	ci *connInfo
+}
+
+// RemoteAddr obtains the address of the peer
+func (c *Conn) RemoteAddr() netip.AddrPort {
+	return c.ci.addr
+}
+
+// OutputChan obtains a receivable channel for payloads received from the peer
+func (c *Conn) OutputChan() <-chan []byte {
+	return c.ci.outputChan
+}
+
+// Done obtains a channel that is closed once the connection is closed by either side
+func (c *Conn) Done() <-chan struct{} {
+	return c.ci.closed
+}
+
+// Err obtains the reason the connection closed, or nil if it is still open
+func (c *Conn) Err() error {
+	c.ci.mu.Lock()
+	defer c.ci.mu.Unlock()
+	return c.ci.err
+}
+
+// Send queues payload for the peer, waiting while the send window is full.
+// payload must be at most message.MaxPayloadSize bytes and must not be modified afterwards.
+func (c *Conn) Send(ctx context.Context, payload []byte) error {
+	err := c.ci.send(ctx.Done(), payload)
+	if errors.Is(err, ErrSendCanceled) {
+		return ctx.Err()
+	}
+	return err
+}
+
+// SendUntil queues payload like Send, but gives up with ErrSendCanceled once cancel is closed
+func (c *Conn) SendUntil(cancel <-chan struct{}, payload []byte) error {
+	return c.ci.send(cancel, payload)
+}
+
+// Close stops accepting payloads and waits until all payloads sent so far are acked,
+// then sends a FIN to the peer and waits until it is acknowledged.
+// It returns nil if the connection closed gracefully. Once ctx expires
+// the connection is aborted, discarding any unacked payloads, and ctx.Err() is returned.
+func (c *Conn) Close(ctx context.Context) error {
+	c.ci.requestClose()
+	select {
+	case <-c.ci.closeAcked:
+		return c.ci.closeErr
+	case <-ctx.Done():
+		c.ci.fail(ctx.Err())
+		<-c.ci.closeAcked
+		return c.ci.closeErr
+	}
+}
+
+// Abort closes the connection immediately without notifying the peer,
+// discarding any unacked payloads
+func (c *Conn) Abort() {
+	c.ci.fail(ErrClosed)
+}
+
+// Stats obtains a snapshot of the counters and gauges of the connection
+func (c *Conn) Stats() ConnStats {
+	return c.ci.stats()
+}
+
+// RTO obtains the current retransmission timeout of the connection
+func (c *Conn) RTO() time.Duration {
+	return c.ci.rtt.RTO()
+}
+
+// SRTT obtains the smoothed round trip time of the connection
+func (c *Conn) SRTT() time.Duration {
+	return c.ci.rtt.SRTT()
+}
+
+// RTTVar obtains the round trip time variation of the connection
+func (c *Conn) RTTVar() time.Duration {
+	return c.ci.rtt.RTTVar()
+}
+
+// CongestionWindow obtains the congestion window of the connection in messages
+func (c *Conn) CongestionWindow() uint32 {
+	return c.ci.cc.Window()
+}
+
+// SSThresh obtains the slow start threshold of the connection in messages
+func (c *Conn) SSThresh() uint32 {
+	return c.ci.cc.SSThresh()
+}
@@ -0,0 +1,606 @@
+package gbn
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/netip"
+	"rdt/internal/clock"
+	"rdt/internal/message"
+	"rdt/internal/trace"
+	"rdt/internal/util"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClosed           = errors.New("connection closed")
+	ErrPeerClosed       = errors.New("connection closed by peer")
+	ErrHandshakeTimeout = errors.New("connection handshake timed out")
+	ErrSendCanceled     = errors.New("send canceled")
+	ErrIdleTimeout      = errors.New("connection evicted after idle timeout")
+	ErrIncompatiblePeer = errors.New("peer uses a different protocol or sequence no. space")
+)
+
+// connState is a state of the connection establishment and teardown state machine
+type connState int
+
+const (
+	stateSynSent     connState = iota // SYN sent, awaiting SYNACK
+	stateSynReceived                  // SYNACK sent, awaiting first ACK or DATA
+	stateEstablished                  // data may flow in both directions
+	stateDraining                     // close requested, awaiting acks for all sent data
+	stateCloseWait                    // FIN received, delivering buffered data before reporting the closure
+	stateFinWait                      // FIN sent, awaiting FINACK
+	stateTimeWait                     // FINACK received, lingering to absorb stray messages
+)
+
+var stateNames = map[connState]string{
+	stateSynSent:     "SYN_SENT",
+	stateSynReceived: "SYN_RCVD",
+	stateEstablished: "ESTABLISHED",
+	stateDraining:    "DRAINING",
+	stateCloseWait:   "CLOSE_WAIT",
+	stateFinWait:     "FIN_WAIT",
+	stateTimeWait:    "TIME_WAIT",
+}
+
+func (s connState) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("connState(%d)", int(s))
+}
+
+// connInfo holds the state of the connection with a single peer
+type connInfo struct {
+	id     uint64 // identifies the connection in log records and traces
+	addr   netip.AddrPort
+	mux    *Multiplexer
+	logger *slog.Logger      // logs with the connection id and peer
+	tracer *trace.ConnTracer // records protocol decisions of the connection
+	// local recv channels fed by the multiplexer
+	localSenderRecvChan   chan *message.AddressedMessage
+	localReceiverRecvChan chan *message.AddressedMessage
+	localControlRecvChan  chan *message.AddressedMessage
+	// user channels
+	inputChan   chan []byte
+	outputChan  chan []byte
+	inputMu     sync.RWMutex  // held for reading while a payload is queued so that closing can wait for it
+	inputClosed chan struct{} // closed once no more payloads are accepted
+	// protocol halves, started once both initial sequence nos. are known
+	sender   dataSender
+	receiver dataReceiver
+	rtt      *RTTEstimator
+	cc       CongestionController
+	counters connCounters
+	// connection state, guarded by mu
+	mu          sync.Mutex
+	state       connState
+	localISN    uint32    // initial sequence no. of data sent to peer
+	peerISN     uint32    // initial sequence no. of data received from peer
+	window      uint32    // window size agreed in the handshake
+	cookie      uint32    // cookie the server challenged our SYN with, or 0
+	retries     int       // retransmissions of the current control message
+	sentAt      time.Time // time the current control message was first sent
+	lastHeard   time.Time // time the last message from the peer was received
+	err         error     // reason the connection closed
+	established chan struct{}
+	closed      chan struct{}
+	closeAcked  chan struct{}
+	closeReq    chan struct{}
+	closeErr    error           // reason closing did not complete gracefully, set before closeAcked is closed
+	drained     <-chan struct{} // closed once all sent data is acked while draining, only used by the control loop
+	delivered   <-chan struct{} // closed once all received data is delivered in CLOSE_WAIT, only used by the control loop
+	closeOnce   sync.Once
+	ackOnce     sync.Once
+	stopOnce    sync.Once
+	// timers
+	retransmitTimer *TimeoutTimer
+	lingerTimer     *TimeoutTimer
+	idleTimer       *TimeoutTimer
+	keepaliveTimer  *TimeoutTimer
+	// termination channels
+	term *util.Terminator // control loop
+}
+
+func newConnInfo(mux *Multiplexer, addr netip.AddrPort, state connState) *connInfo {
+	id := mux.nextConnID.Add(1)
+	var onSample func(time.Duration)
+	if observe := mux.cfg.OnRTTSample; observe != nil {
+		onSample = func(rtt time.Duration) { observe(addr, rtt) }
+	}
+	return &connInfo{
+		id:                    id,
+		addr:                  addr,
+		mux:                   mux,
+		logger:                mux.cfg.Logger.With("conn", id, "peer", addr.String()),
+		tracer:                mux.tracer.Conn(id, addr),
+		localSenderRecvChan:   make(chan *message.AddressedMessage, mux.cfg.LocalSenderRecvChanBufferSize),
+		localReceiverRecvChan: make(chan *message.AddressedMessage, mux.cfg.LocalReceiverRecvChanBufferSize),
+		localControlRecvChan:  make(chan *message.AddressedMessage, mux.cfg.LocalControlRecvChanBufferSize),
+		inputChan:             make(chan []byte, mux.cfg.InputChanBufferSize),
+		outputChan:            make(chan []byte, mux.cfg.OutputChanBufferSize),
+		inputClosed:           make(chan struct{}),
+		state:                 state,
+		localISN:              rand.Uint32N(mux.cfg.MaxSeqNo),
+		window:                min(mux.cfg.WindowSize, 0xFFFF),
+		established:           make(chan struct{}),
+		closed:                make(chan struct{}),
+		closeAcked:            make(chan struct{}),
+		closeReq:              make(chan struct{}),
+		rtt:                   NewRTTEstimator(mux.cfg.InitialRTO, mux.cfg.MinRTO, mux.cfg.MaxRTO, onSample),
+		cc:                    mux.newCC(),
+		retransmitTimer:       NewTimeoutTimer(mux.cfg.Clock, mux.cfg.HandshakeTimeout),
+		lingerTimer:           NewTimeoutTimer(mux.cfg.Clock, mux.cfg.TimeWaitDuration),
+		idleTimer:             NewTimeoutTimer(mux.cfg.Clock, mux.cfg.IdleTimeout),
+		keepaliveTimer:        NewTimeoutTimer(mux.cfg.Clock, mux.cfg.KeepaliveInterval),
+		lastHeard:             mux.cfg.Clock.Now(),
+		term:                  util.NewTerminator(),
+	}
+}
+
+// runControl runs the connection state machine until the connection is finished
+func (ci *connInfo) runControl() {
+	defer ci.term.Done()
+	defer ci.finish()
+
+	ci.mu.Lock()
+	if ci.state == stateSynSent {
+		ci.sendSyn()
+	} else {
+		ci.startData()
+		ci.sendControl(message.TypeSynAck, ci.localISN)
+	}
+	ci.sentAt = ci.mux.cfg.Clock.Now()
+	ci.retransmitTimer.Start()
+	if ci.mux.cfg.IdleTimeout > 0 {
+		ci.idleTimer.Start()
+	}
+	if ci.mux.cfg.KeepaliveInterval > 0 {
+		ci.keepaliveTimer.Start()
+	}
+	ci.mu.Unlock()
+
+	closeReq := ci.closeReq
+	for {
+		select {
+		case <-ci.term.Quit():
+			return
+		case msg := <-ci.localControlRecvChan:
+			if ci.handleControl(msg) {
+				return
+			}
+		case <-closeReq:
+			if ci.handleClose() {
+				return
+			}
+			// only handle the close request once
+			closeReq = nil
+		case <-ci.drained:
+			ci.handleDrained()
+		case <-ci.delivered:
+			ci.handleDelivered()
+			return
+		case <-ci.retransmitTimer.Channel():
+			if ci.handleRetransmit() {
+				return
+			}
+		case <-ci.lingerTimer.Channel():
+			return
+		case <-ci.idleTimer.Channel():
+			if ci.handleIdle() {
+				return
+			}
+		case <-ci.keepaliveTimer.Channel():
+			ci.handleKeepalive()
+		}
+	}
+}
+
+// handleControl advances the state machine on a control message from the peer,
+// returning true once the connection is finished
+func (ci *connInfo) handleControl(msg *message.AddressedMessage) bool {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	switch msg.Type {
+	case message.TypeSyn:
+		if ci.state == stateSynSent || msg.SeqNo != ci.peerISN {
+			// a SYN with a new ISN is left to the multiplexer, which replaces the connection once the peer proves its address
+			return false
+		}
+		if ci.state == stateSynReceived || ci.state == stateEstablished {
+			// our SYNACK was lost
+			ci.sendControl(message.TypeSynAck, ci.localISN)
+		}
+	case message.TypeSynAck:
+		switch ci.state {
+		case stateSynSent:
+			if err := ci.mux.cfg.checkOffer(msg); err != nil {
+				// the peer refused our SYN, or would misread our sequence nos.
+				ci.logger.Warn("connection refused", "err", err)
+				ci.setClosed(err)
+				ci.ackClose(err)
+				return true
+			}
+			if msg.Window == 0 {
+				return false
+			}
+			ci.peerISN = msg.SeqNo
+			ci.window = min(ci.window, uint32(msg.Window))
+			ci.sampleHandshake()
+			ci.startData()
+			ci.state = stateEstablished
+			close(ci.established)
+			ci.logger.Info("connection established", "window", ci.window, "srtt", ci.rtt.SRTT())
+			ci.traceState()
+			ci.retransmitTimer.Stop()
+			ci.sendHandshakeAck()
+		case stateEstablished:
+			if msg.SeqNo == ci.peerISN {
+				// our handshake ACK was lost
+				ci.sendHandshakeAck()
+			}
+		}
+	case message.TypeFin:
+		if ci.state == stateSynSent || msg.SeqNo != ci.peerISN {
+			return false
+		}
+		ci.sendControl(message.TypeFinAck, msg.SeqNo)
+		switch ci.state {
+		case stateSynReceived:
+			ci.setClosed(ErrPeerClosed)
+			return true
+		case stateEstablished:
+			// stop accepting data but let the user read what was already received
+			ci.state = stateCloseWait
+			ci.delivered = ci.receiver.Drain()
+		case stateDraining:
+			// peer stopped receiving before all of our data was acked
+			ci.ackClose(ErrPeerClosed)
+			return true
+		case stateFinWait:
+			// both sides closed simultaneously
+			ci.enterTimeWait()
+		}
+	case message.TypeFinAck:
+		if ci.state == stateFinWait && msg.SeqNo == ci.localISN {
+			ci.enterTimeWait()
+		}
+	case message.TypeKeepalive:
+		if ci.state == stateEstablished || ci.state == stateDraining {
+			ci.sendControl(message.TypeKeepaliveAck, msg.SeqNo)
+		}
+	case message.TypeCookie:
+		// a repeated cookie answers a retransmitted SYN, which is left to the retransmit timer
+		if ci.state == stateSynSent && msg.SeqNo != ci.cookie {
+			// prove our address by repeating the request with the cookie
+			ci.cookie = msg.SeqNo
+			ci.sentAt = ci.mux.cfg.Clock.Now()
+			ci.sendSyn()
+			ci.retransmitTimer.Start()
+		}
+	}
+	return false
+}
+
+// handleClose starts closing the connection on behalf of the user,
+// returning true once the connection is finished.
+// An established connection first drains the data the user already sent.
+func (ci *connInfo) handleClose() bool {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	switch ci.state {
+	case stateSynSent:
+		ci.setClosed(ErrClosed)
+		return true
+	case stateSynReceived:
+		ci.setClosed(ErrClosed)
+		ci.sendFin()
+	case stateEstablished:
+		ci.setClosed(ErrClosed)
+		ci.state = stateDraining
+		ci.drained = ci.sender.Drain()
+	case stateCloseWait:
+		// the peer is gone, so the user gives up on the data it has not read
+		ci.setClosed(ErrClosed)
+		return true
+	}
+	return false
+}
+
+// handleDrained sends the FIN once all data sent by the user is acked
+func (ci *connInfo) handleDrained() {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	ci.drained = nil
+	if ci.state == stateDraining {
+		ci.sendFin()
+	}
+}
+
+// handleDelivered reports the closure by the peer once the user received all of its data
+func (ci *connInfo) handleDelivered() {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	ci.setClosed(ErrPeerClosed)
+}
+
+// sendFin moves to FIN_WAIT and sends the first FIN
+func (ci *connInfo) sendFin() {
+	ci.state = stateFinWait
+	ci.retries = 0
+	ci.sendControl(message.TypeFin, ci.localISN)
+	ci.retransmitTimer.Start()
+}
+
+// handleRetransmit resends the pending control message,
+// returning true once the peer is considered unreachable
+func (ci *connInfo) handleRetransmit() bool {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	var t message.Type
+	switch ci.state {
+	case stateSynSent:
+		t = message.TypeSyn
+	case stateSynReceived:
+		t = message.TypeSynAck
+	case stateFinWait:
+		t = message.TypeFin
+	default:
+		return false
+	}
+	ci.retries++
+	if ci.retries > ci.mux.cfg.HandshakeRetries {
+		ci.logger.Warn("peer unreachable", "state", ci.state, "retries", ci.mux.cfg.HandshakeRetries)
+		ci.setClosed(ErrHandshakeTimeout)
+		ci.ackClose(ErrHandshakeTimeout)
+		return true
+	}
+	if t == message.TypeSyn {
+		ci.sendSyn()
+		ci.retransmitTimer.Start()
+		return false
+	}
+	ci.sendControl(t, ci.localISN)
+	ci.retransmitTimer.Start()
+	return false
+}
+
+// handleIdle evicts the connection once nothing was heard from the peer for the idle timeout,
+// returning true if it was evicted
+func (ci *connInfo) handleIdle() bool {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	idle := clock.Since(ci.mux.cfg.Clock, ci.lastHeard)
+	if idle < ci.mux.cfg.IdleTimeout {
+		// heard from peer since the timer started
+		ci.idleTimer.SetDuration(ci.mux.cfg.IdleTimeout - idle)
+		ci.idleTimer.Start()
+		return false
+	}
+	ci.logger.Warn("connection evicted", "idle", idle)
+	ci.setClosed(ErrIdleTimeout)
+	ci.ackClose(ErrIdleTimeout)
+	ci.mux.counters.evicted.Add(1)
+	return true
+}
+
+// handleKeepalive probes the peer once nothing was heard from it for the keepalive interval
+func (ci *connInfo) handleKeepalive() {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	idle := clock.Since(ci.mux.cfg.Clock, ci.lastHeard)
+	if idle < ci.mux.cfg.KeepaliveInterval {
+		ci.keepaliveTimer.SetDuration(ci.mux.cfg.KeepaliveInterval - idle)
+		ci.keepaliveTimer.Start()
+		return
+	}
+	if ci.state == stateEstablished || ci.state == stateDraining {
+		ci.sendControl(message.TypeKeepalive, ci.localISN)
+	}
+	ci.keepaliveTimer.SetDuration(ci.mux.cfg.KeepaliveInterval)
+	ci.keepaliveTimer.Start()
+}
+
+// restartedBy reports whether syn requests a new connection with the peer
+// rather than repeating the request that opened this one
+func (ci *connInfo) restartedBy(syn *message.AddressedMessage) bool {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	return ci.state != stateSynSent && syn.SeqNo != ci.peerISN
+}
+
+// touch records that a message from the peer was received
+func (ci *connInfo) touch() {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	ci.lastHeard = ci.mux.cfg.Clock.Now()
+}
+
+// markEstablished completes the handshake of a connection in SYN_RCVD,
+// returning true if the connection was newly established
+func (ci *connInfo) markEstablished() bool {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	if ci.state != stateSynReceived {
+		return false
+	}
+	ci.state = stateEstablished
+	close(ci.established)
+	ci.retransmitTimer.Stop()
+	ci.sampleHandshake()
+	ci.logger.Info("connection established", "window", ci.window, "srtt", ci.rtt.SRTT())
+	ci.traceState()
+	return true
+}
+
+// sampleHandshake seeds the round trip time estimate from the handshake
+// unless the handshake message was retransmitted (Karn's rule)
+func (ci *connInfo) sampleHandshake() {
+	if ci.retries == 0 {
+		ci.rtt.Sample(clock.Since(ci.mux.cfg.Clock, ci.sentAt))
+	}
+}
+
+// isEstablished reports whether data may flow on the connection
+func (ci *connInfo) isEstablished() bool {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	return ci.state == stateEstablished
+}
+
+// carriesData reports whether data and acks are still exchanged on the connection
+func (ci *connInfo) carriesData() bool {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	return ci.state == stateEstablished || ci.state == stateDraining
+}
+
+// send queues payload for the sender unless the connection is closing,
+// giving up with ErrSendCanceled once cancel is closed
+func (ci *connInfo) send(cancel <-chan struct{}, payload []byte) error {
+	ci.inputMu.RLock()
+	defer ci.inputMu.RUnlock()
+	select {
+	case <-ci.inputClosed:
+		return ErrClosed
+	default:
+	}
+	select {
+	case <-ci.inputClosed:
+		return ErrClosed
+	case <-ci.closed:
+		ci.mu.Lock()
+		defer ci.mu.Unlock()
+		return ci.err
+	case <-cancel:
+		return ErrSendCanceled
+	case ci.inputChan <- payload:
+		return nil
+	}
+}
+
+// requestClose stops accepting payloads and asks the control loop to close the connection
+func (ci *connInfo) requestClose() {
+	ci.closeOnce.Do(func() {
+		close(ci.inputClosed)
+		// wait for payloads being queued so that the sender can tell when it is drained
+		ci.inputMu.Lock()
+		ci.inputMu.Unlock()
+		close(ci.closeReq)
+	})
+}
+
+// enterTimeWait moves to TIME_WAIT once our FIN has been acknowledged
+func (ci *connInfo) enterTimeWait() {
+	ci.state = stateTimeWait
+	ci.retransmitTimer.Stop()
+	ci.lingerTimer.Start()
+	ci.ackClose(nil)
+}
+
+// ackClose notifies the user that closing the connection has completed,
+// where a non-nil err tells why it did not complete gracefully
+func (ci *connInfo) ackClose(err error) {
+	ci.ackOnce.Do(func() {
+		ci.closeErr = err
+		close(ci.closeAcked)
+	})
+}
+
+// setClosed records why the connection closed and notifies the user
+func (ci *connInfo) setClosed(err error) {
+	if ci.err != nil {
+		return
+	}
+	ci.err = err
+	close(ci.closed)
+}
+
+// traceState records the current state along with what is needed to replay the sequence nos. of the connection
+func (ci *connInfo) traceState() {
+	ci.tracer.Emit(trace.Event{Kind: trace.KindState, Seq: ci.localISN, Window: ci.window, MaxSeqNo: ci.mux.cfg.MaxSeqNo, Reason: ci.state.String()})
+}
+
+// startData starts the sender and receiver once both initial sequence nos. are known
+func (ci *connInfo) startData() {
+	switch ci.mux.cfg.Mode {
+	case SelectiveRepeat:
+		ci.sender = NewSRSender(ci.mux.cfg, ci.mux.sendChan, ci.localSenderRecvChan, ci.inputChan, ci.addr, ci.localISN, ci.window, ci.rtt, ci.cc, &ci.counters, ci.logger, ci.tracer)
+		ci.receiver = NewSRReceiver(ci.mux.cfg, ci.mux.sendChan, ci.localReceiverRecvChan, ci.outputChan, ci.addr, ci.peerISN, ci.window, &ci.counters, ci.tracer)
+	default:
+		ci.sender = NewSender(ci.mux.cfg, ci.mux.sendChan, ci.localSenderRecvChan, ci.inputChan, ci.addr, ci.localISN, ci.window, ci.rtt, ci.cc, &ci.counters, ci.logger, ci.tracer)
+		ci.receiver = NewReceiver(ci.mux.cfg, ci.mux.sendChan, ci.localReceiverRecvChan, ci.outputChan, ci.addr, ci.peerISN, &ci.counters, ci.tracer)
+	}
+	go ci.sender.Start()
+	go ci.receiver.Start()
+}
+
+// finish stops the protocol halves and removes the connection from the multiplexer
+func (ci *connInfo) finish() {
+	ci.retransmitTimer.Stop()
+	ci.lingerTimer.Stop()
+	ci.idleTimer.Stop()
+	ci.keepaliveTimer.Stop()
+	ci.mu.Lock()
+	ci.setClosed(ErrClosed)
+	reason := ci.err
+	ci.mu.Unlock()
+	ci.logger.Info("connection closed", "reason", reason)
+	ci.tracer.Emit(trace.Event{Kind: trace.KindState, Reason: "CLOSED"})
+	if ci.sender != nil {
+		ci.sender.Stop()
+		ci.receiver.Stop()
+	}
+	ci.mux.forget(ci)
+	ci.ackClose(nil)
+}
+
+// stop finishes the connection immediately without notifying the peer
+func (ci *connInfo) stop() {
+	ci.stopOnce.Do(ci.term.Terminate)
+}
+
+// fail finishes the connection immediately, recording err as the reason it closed
+// and, unless closing already completed, as the reason closing did not complete
+func (ci *connInfo) fail(err error) {
+	ci.mu.Lock()
+	ci.setClosed(err)
+	ci.mu.Unlock()
+	ci.ackClose(err)
+	ci.stop()
+}
+
+// sendControl sends a control message of type t to the peer
+func (ci *connInfo) sendControl(t message.Type, seqNo uint32) {
+	msg := message.NewControlMessage(ci.addr, t, seqNo, uint16(ci.window))
+	if t == message.TypeSynAck {
+		ci.mux.cfg.offer(msg)
+	}
+	select {
+	case <-ci.term.Quit():
+	case ci.mux.sendChan <- msg:
+	}
+}
+
+// sendSyn requests a connection, echoing the cookie of the last challenge if there was one
+func (ci *connInfo) sendSyn() {
+	msg := message.NewControlMessage(ci.addr, message.TypeSyn, ci.localISN, uint16(ci.window))
+	ci.mux.cfg.offer(msg)
+	msg.Cookie = ci.cookie
+	select {
+	case <-ci.term.Quit():
+	case ci.mux.sendChan <- msg:
+	}
+}
+
+// sendHandshakeAck completes the handshake with an ACK expecting the peer's first message
+func (ci *connInfo) sendHandshakeAck() {
+	ackMsg := message.NewAckMessage(ci.addr, (ci.peerISN-1+ci.mux.cfg.MaxSeqNo)%ci.mux.cfg.MaxSeqNo, uint16(min(ci.mux.cfg.ReceiveBufferSize, 0xFFFF)))
+	select {
+	case <-ci.term.Quit():
+	case ci.mux.sendChan <- ackMsg:
+	}
+}
@@ -0,0 +1,96 @@
+package gbn
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"rdt/internal/clock"
+	"rdt/internal/simnet"
+	"testing"
+	"time"
+)
+
+func TestPeerCloseDeliversBufferedData(t *testing.T) {
+	for _, mode := range []Mode{GoBackN, SelectiveRepeat} {
+		t.Run(mode.String(), func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Mode = mode
+			client, server := newTestPair(t, cfg, simnet.Config{Delay: time.Millisecond})
+			c, s := dialAccept(t, client, server)
+
+			// more payloads than the user channel holds, all acked before the FIN
+			const count, size = 20, 1200
+			var want [][]byte
+			for i := range count {
+				p := bytes.Repeat([]byte{byte(i)}, size)
+				want = append(want, p)
+				if err := c.Send(context.Background(), p); err != nil {
+					t.Fatal("send:", err)
+				}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := c.Close(ctx); err != nil {
+				t.Fatal("close:", err)
+			}
+
+			// the server only starts reading after the FIN was handled
+			time.Sleep(100 * time.Millisecond)
+			got := receive(s, 5*time.Second)
+			if len(got) != count {
+				t.Fatalf("received %d payloads, want %d", len(got), count)
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], want[i]) {
+					t.Fatalf("payload %d differs", i)
+				}
+			}
+			if err := s.Err(); !errors.Is(err, ErrPeerClosed) {
+				t.Fatalf("closed with %v, want %v", err, ErrPeerClosed)
+			}
+		})
+	}
+}
+
+// TestKeepaliveAndIdleEviction checks that keepalives keep a quiet connection with a live peer open,
+// while a connection whose peer went away is evicted after the idle timeout
+func TestKeepaliveAndIdleEviction(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	cfg := DefaultConfig()
+	cfg.Clock = clk
+	cfg.IdleTimeout = 60 * time.Second
+	cfg.KeepaliveInterval = 15 * time.Second
+	client, server := newTestPair(t, cfg, simnet.Config{})
+	c, s := dialAccept(t, client, server)
+	waitFor(t, "timers armed", func() bool { return clk.Pending() > 0 })
+
+	// step through fake time, giving the goroutines time to react to each step
+	advance := func(d time.Duration) {
+		for end := clk.Now().Add(d); clk.Now().Before(end); {
+			clk.Advance(time.Second)
+			time.Sleep(2 * time.Millisecond)
+		}
+	}
+
+	sent := server.Stats().PacketsSent
+	advance(2 * cfg.IdleTimeout)
+	if err := s.Err(); err != nil {
+		t.Fatal("quiet connection with a live peer closed:", err)
+	}
+	if err := c.Err(); err != nil {
+		t.Fatal("quiet connection with a live peer closed:", err)
+	}
+	if server.Stats().PacketsSent == sent {
+		t.Fatal("no keepalives sent")
+	}
+
+	// the peer goes away without a FIN
+	client.Abort()
+	advance(cfg.IdleTimeout + cfg.KeepaliveInterval)
+	if err := s.Err(); !errors.Is(err, ErrIdleTimeout) {
+		t.Fatalf("closed with %v, want %v", err, ErrIdleTimeout)
+	}
+	if evicted := server.Metrics().EvictedPeers; evicted != 1 {
+		t.Fatalf("evicted %d peers, want 1", evicted)
+	}
+}
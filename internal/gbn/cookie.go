@@ -0,0 +1,61 @@
+package gbn
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net/netip"
+	"rdt/internal/clock"
+	"time"
+)
+
+// cookiePeriod is the minimum time a cookie remains valid after it was issued
+const cookiePeriod = 30 * time.Second
+
+// cookieJar issues and verifies stateless cookies, which prove that a peer
+// receives datagrams sent to its source address before any state is allocated for it
+type cookieJar struct {
+	secret [32]byte
+	clock  clock.Clock
+}
+
+func newCookieJar(clk clock.Clock) *cookieJar {
+	j := &cookieJar{clock: clk}
+	_, _ = rand.Read(j.secret[:])
+	return j
+}
+
+// issue obtains the cookie for the SYN from addr with isn and window
+func (j *cookieJar) issue(addr netip.AddrPort, isn uint32, window uint16) uint32 {
+	return j.compute(addr, isn, window, j.epoch())
+}
+
+// verify reports whether cookie was issued for the SYN from addr with isn and window
+// during the current or the previous period
+func (j *cookieJar) verify(addr netip.AddrPort, isn uint32, window uint16, cookie uint32) bool {
+	epoch := j.epoch()
+	return cookie == j.compute(addr, isn, window, epoch) || cookie == j.compute(addr, isn, window, epoch-1)
+}
+
+func (j *cookieJar) epoch() uint64 {
+	return uint64(j.clock.Now().UnixNano() / int64(cookiePeriod))
+}
+
+// compute derives a cookie from the secret, the SYN and the epoch
+func (j *cookieJar) compute(addr netip.AddrPort, isn uint32, window uint16, epoch uint64) uint32 {
+	ip := addr.Addr().As16()
+	data := append(ip[:], 0, 0)
+	binary.BigEndian.PutUint16(data[16:], addr.Port())
+	data = binary.BigEndian.AppendUint32(data, isn)
+	data = binary.BigEndian.AppendUint16(data, window)
+	data = binary.BigEndian.AppendUint64(data, epoch)
+	mac := hmac.New(sha256.New, j.secret[:])
+	_, _ = mac.Write(data)
+	cookie := binary.BigEndian.Uint32(mac.Sum(nil))
+	if cookie == 0 {
+		// 0 means a SYN carries no cookie
+		cookie = 1
+	}
+	return cookie
+}
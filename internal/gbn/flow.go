@@ -0,0 +1,158 @@
+package gbn
+
+import (
+	"net/netip"
+	"rdt/internal/message"
+	"sync"
+	"time"
+)
+
+// windowProber probes a peer that advertised a zero receive window while nothing is in flight,
+// since the ack reopening the window may be lost
+type windowProber struct {
+	cfg      *Config
+	timer    *TimeoutTimer
+	interval time.Duration // delay before the next probe, doubled after each probe
+	probing  bool
+}
+
+func newWindowProber(cfg *Config) *windowProber {
+	return &windowProber{cfg: cfg, timer: NewTimeoutTimer(cfg.Clock, 0)}
+}
+
+// update starts probing once the window is closed with nothing in flight and stops once it reopens
+func (p *windowProber) update(rwnd uint32, inFlight uint32, rto time.Duration) {
+	if rwnd == 0 && inFlight == 0 {
+		if !p.probing {
+			p.probing = true
+			p.interval = rto
+			p.timer.SetDuration(p.interval)
+			p.timer.Start()
+		}
+		return
+	}
+	if p.probing {
+		p.probing = false
+		p.timer.Stop()
+	}
+}
+
+// probe sends a probe and schedules the next one.
+// The probe is an empty message with the already acked sequence no. before baseSeqNo,
+// which the receiver answers with an ack carrying its current window.
+func (p *windowProber) probe(sendQueue chan<- *message.AddressedMessage, addr netip.AddrPort, baseSeqNo uint32) {
+	seqNo := (baseSeqNo - 1 + p.cfg.MaxSeqNo) % p.cfg.MaxSeqNo
+	sendQueue <- message.NewDataMessage(addr, seqNo, nil)
+	p.interval = min(2*p.interval, p.cfg.MaxRTO)
+	p.timer.SetDuration(p.interval)
+	p.timer.Start()
+}
+
+// Channel obtains a channel where the current time will be sent when the next probe is due
+func (p *windowProber) Channel() <-chan time.Time {
+	return p.timer.Channel()
+}
+
+// drainer tracks a drain request to a sender or receiver, which must stop being fed new input beforehand
+type drainer struct {
+	req      chan struct{} // closed once a drain is requested
+	done     chan struct{} // closed once the sender is idle after a drain was requested
+	reqOnce  sync.Once
+	draining bool
+}
+
+func newDrainer() *drainer {
+	return &drainer{req: make(chan struct{}), done: make(chan struct{})}
+}
+
+// request asks for a drain, returning the channel that is closed once it completes
+func (d *drainer) request() <-chan struct{} {
+	d.reqOnce.Do(func() {
+		close(d.req)
+	})
+	return d.done
+}
+
+// Requested obtains a channel that is closed once a drain is requested,
+// or nil once the sender or receiver noticed it
+func (d *drainer) Requested() <-chan struct{} {
+	select {
+	case <-d.done:
+		return nil
+	default:
+	}
+	if d.draining {
+		return nil
+	}
+	return d.req
+}
+
+// start records that the sender or receiver noticed the drain request
+func (d *drainer) start() {
+	d.draining = true
+}
+
+// update completes the drain once the sender or receiver is idle
+func (d *drainer) update(idle bool) {
+	if d.draining && idle {
+		d.draining = false
+		close(d.done)
+	}
+}
+
+// receiveBuffer queues in-order payloads until the user reads them,
+// so that acks keep flowing while the user is slow
+type receiveBuffer struct {
+	queue      [][]byte
+	capacity   int
+	advertised uint16 // window advertised in the last ack
+}
+
+func newReceiveBuffer(capacity int) *receiveBuffer {
+	return &receiveBuffer{
+		queue:      make([][]byte, 0, capacity),
+		capacity:   capacity,
+		advertised: uint16(min(capacity, 0xFFFF)),
+	}
+}
+
+// push queues payload, returning false if the buffer is full
+func (b *receiveBuffer) push(payload []byte) bool {
+	if len(b.queue) == b.capacity {
+		return false
+	}
+	b.queue = append(b.queue, payload)
+	return true
+}
+
+// next obtains the channel to deliver the oldest payload on and the payload itself,
+// or a nil channel if the buffer is empty
+func (b *receiveBuffer) next(outputChan chan<- []byte) (chan<- []byte, []byte) {
+	if len(b.queue) == 0 {
+		return nil, nil
+	}
+	return outputChan, b.queue[0]
+}
+
+// pop removes the oldest payload after it was delivered
+func (b *receiveBuffer) pop() {
+	b.queue[0] = nil
+	b.queue = b.queue[1:]
+}
+
+// window obtains the free space to advertise and records it as advertised
+func (b *receiveBuffer) window() uint16 {
+	b.advertised = uint16(min(b.capacity-len(b.queue), 0xFFFF))
+	return b.advertised
+}
+
+// empty reports whether all payloads were delivered
+func (b *receiveBuffer) empty() bool {
+	return len(b.queue) == 0
+}
+
+// reopened reports whether a zero window was advertised
+// and enough space has been freed since to be worth a window update
+func (b *receiveBuffer) reopened() bool {
+	return b.advertised == 0 && b.capacity-len(b.queue) >= max(b.capacity/4, 1)
+}
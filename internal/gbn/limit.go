@@ -0,0 +1,72 @@
+package gbn
+
+import (
+	"net/netip"
+	"rdt/internal/clock"
+	"time"
+)
+
+// maxBuckets bounds the number of source IPs a rateLimiter tracks
+const maxBuckets = 4096
+
+// rateLimiter limits connection attempts from each source IP with a token bucket.
+// It is only used by the receive loop of a multiplexer, so it needs no locking.
+type rateLimiter struct {
+	rate    float64 // tokens added per second, where 0 disables limiting
+	burst   float64 // capacity of each bucket
+	clock   clock.Clock
+	buckets map[netip.Addr]*bucket
+}
+
+// bucket holds the tokens of a source IP
+type bucket struct {
+	tokens float64
+	last   time.Time // time tokens were last added
+}
+
+func newRateLimiter(rate float64, burst int, clk clock.Clock) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		clock:   clk,
+		buckets: make(map[netip.Addr]*bucket),
+	}
+}
+
+// allow reports whether an attempt from ip is within its rate, taking a token if it is
+func (l *rateLimiter) allow(ip netip.Addr) bool {
+	if l.rate == 0 {
+		return true
+	}
+	ip = ip.Unmap()
+	now := l.clock.Now()
+	b, ok := l.buckets[ip]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.prune(now)
+		}
+		if len(l.buckets) >= maxBuckets {
+			// too many sources to track, which cookies and the peer cap still protect against
+			return true
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune forgets sources whose buckets have refilled completely,
+// since they are indistinguishable from sources never seen
+func (l *rateLimiter) prune(now time.Time) {
+	for ip, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+}
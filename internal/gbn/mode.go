@@ -0,0 +1,52 @@
+package gbn
+
+import "fmt"
+
+// Mode selects the pipelined reliable data transfer protocol used by a transport
+type Mode int
+
+const (
+	GoBackN         Mode = iota // cumulative acks, whole window resent on timeout
+	SelectiveRepeat             // individual acks, only lost messages resent
+)
+
+// ParseMode parses the name of a protocol mode
+func ParseMode(name string) (Mode, error) {
+	switch name {
+	case "gbn":
+		return GoBackN, nil
+	case "sr":
+		return SelectiveRepeat, nil
+	default:
+		return 0, fmt.Errorf("unknown protocol mode %q", name)
+	}
+}
+
+func (m Mode) String() string {
+	switch m {
+	case GoBackN:
+		return "gbn"
+	case SelectiveRepeat:
+		return "sr"
+	default:
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+}
+
+// dataSender is the sender half of a protocol mode
+type dataSender interface {
+	Start()
+	Stop()
+	// Drain asks the sender to send the payloads left in its input channel,
+	// returning a channel that is closed once all sent payloads are acked
+	Drain() <-chan struct{}
+}
+
+// dataReceiver is the receiver half of a protocol mode
+type dataReceiver interface {
+	Start()
+	Stop()
+	// Drain asks the receiver to deliver the payloads it buffered to the user,
+	// returning a channel that is closed once all of them are delivered
+	Drain() <-chan struct{}
+}
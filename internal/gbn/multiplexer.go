@@ -1,61 +1,94 @@
 package gbn
 
 import (
+	"context"
+	"errors"
+	"log/slog"
 	"net/netip"
-	"rdt/internal/config"
 	"rdt/internal/message"
+	"rdt/internal/trace"
 	"rdt/internal/util"
 	"sync"
+	"sync/atomic"
 )
 
+var ErrAlreadyConnected = errors.New("already connected to address")
+
 type Multiplexer struct {
+	cfg          *Config
 	sendChan     chan *message.AddressedMessage
 	recvChan     chan *message.AddressedMessage
-	inputChan    chan rune
-	outputChan   chan rune
+	acceptChan   chan *Conn
 	connInfos    map[netip.AddrPort]*connInfo
 	mu           sync.RWMutex
 	recvTerm     *util.Terminator
-	inputTerm    *util.Terminator
 	autoRegister bool
+	refusing     bool         // set once new connections are refused, guarded by mu
+	limiter      *rateLimiter // limits connection requests per source IP
+	cookies      *cookieJar   // challenges connection requests
+	counters     counters
+	nextConnID   atomic.Uint64               // identifies connections in log records and traces
+	tracer       *trace.Tracer               // records protocol decisions if tracing is configured
+	newCC        func() CongestionController // creates the congestion controller of each connection
 }
 
 func NewMultiplexer(
+	cfg *Config,
 	sendChan chan *message.AddressedMessage,
 	recvChan chan *message.AddressedMessage,
-	inputChan chan rune,
-	outputChan chan rune,
 	autoRegister bool,
+	newCC func() CongestionController,
+	tracer *trace.Tracer,
 ) *Multiplexer {
 	return &Multiplexer{
+		cfg:          cfg,
 		sendChan:     sendChan,
 		recvChan:     recvChan,
-		inputChan:    inputChan,
-		outputChan:   outputChan,
+		acceptChan:   make(chan *Conn, cfg.AcceptChanBufferSize),
 		connInfos:    make(map[netip.AddrPort]*connInfo),
 		recvTerm:     util.NewTerminator(),
-		inputTerm:    util.NewTerminator(),
 		autoRegister: autoRegister,
+		newCC:        newCC,
+		tracer:       tracer,
+		limiter:      newRateLimiter(cfg.PeerRateLimit, cfg.PeerRateBurst, cfg.Clock),
+		cookies:      newCookieJar(cfg.Clock),
 	}
 }
 
+// counters holds the events counted by a multiplexer
+type counters struct {
+	evicted              atomic.Uint64
+	challenged           atomic.Uint64
+	rejectedRateLimit    atomic.Uint64
+	rejectedCookie       atomic.Uint64
+	rejectedPeerLimit    atomic.Uint64
+	rejectedIncompatible atomic.Uint64
+}
+
 func (m *Multiplexer) Start() {
 	go m.runRecvChanMux()
-	go m.runInputChanMux()
 }
 
 func (m *Multiplexer) Stop() {
-	m.inputTerm.Terminate()
 	m.recvTerm.Terminate()
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	for _, ci := range m.connInfos {
-		ci.sender.Stop()
-		ci.receiver.Stop()
-		close(ci.waitChan)
-		close(ci.localInputChan)
-		close(ci.localReceiverRecvChan)
-		close(ci.localSenderRecvChan)
+	// a racing dial must not start a connection on the channels that are closed once stopped
+	m.refuse()
+	for _, ci := range m.loadAllConnInfos() {
+		ci.fail(ErrTransportClosed)
+	}
+}
+
+// refuse stops creating connections, both for peers and for dialing
+func (m *Multiplexer) refuse() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refusing = true
+}
+
+// fail closes all connections with err without notifying their peers
+func (m *Multiplexer) fail(err error) {
+	for _, ci := range m.loadAllConnInfos() {
+		ci.fail(err)
 	}
 }
 
@@ -67,75 +100,241 @@ func (m *Multiplexer) runRecvChanMux() {
 		case <-m.recvTerm.Quit():
 			return
 		case msg := <-m.recvChan:
+			if msg.Type.IsControl() {
+				m.handleControl(msg)
+				continue
+			}
 			ci, found := m.loadConnInfo(msg.Addr)
 			if !found {
-				if !m.autoRegister {
-					continue
-				}
-				// register and reload connection info
-				m.registerAddress(msg.Addr)
-				ci, _ = m.loadConnInfo(msg.Addr)
+				continue
 			}
-			if msg.IsAck {
+			ci.touch()
+			// the first ACK or DATA from the peer completes its handshake
+			if ci.markEstablished() {
 				select {
-				case <-m.recvTerm.Quit():
-					return
-				case ci.localSenderRecvChan <- msg:
+				case m.acceptChan <- &Conn{ci: ci}:
+				default:
+					// accept backlog is full so refuse the peer
+					ci.requestClose()
+					continue
 				}
+			}
+			if !ci.carriesData() {
+				continue
+			}
+			var localRecvChan chan *message.AddressedMessage
+			if msg.Type == message.TypeAck {
+				localRecvChan = ci.localSenderRecvChan
 			} else {
-				select {
-				case <-m.recvTerm.Quit():
-					return
-				case ci.localReceiverRecvChan <- msg:
-				}
+				localRecvChan = ci.localReceiverRecvChan
+			}
+			select {
+			case <-m.recvTerm.Quit():
+				return
+			case localRecvChan <- msg:
 			}
 		}
 	}
 }
 
-// runInputChanMux broadcasts inputs from inputChan to all senders
-func (m *Multiplexer) runInputChanMux() {
-	defer m.inputTerm.Done()
-	for {
-		select {
-		case <-m.inputTerm.Quit():
+// handleControl forwards a control message to its connection,
+// creating connections for new peers and answering FINs from forgotten ones
+func (m *Multiplexer) handleControl(msg *message.AddressedMessage) {
+	ci, found := m.loadConnInfo(msg.Addr)
+	if !found {
+		switch {
+		case msg.Type == message.TypeSyn && m.autoRegister && msg.Window != 0:
+			if m.compatible(msg) {
+				m.admit(msg)
+			}
 			return
-		case r := <-m.inputChan:
-			cis := m.loadAllConnInfos()
-			for _, ci := range cis {
-				select {
-				case <-m.inputTerm.Quit():
-					return
-				case ci.waitChan <- r:
-				}
+		case msg.Type == message.TypeFin:
+			// peer missed our FINACK after we forgot it
+			finAck := message.NewControlMessage(msg.Addr, message.TypeFinAck, msg.SeqNo, 0)
+			select {
+			case <-m.recvTerm.Quit():
+			case m.sendChan <- finAck:
 			}
+			return
+		default:
+			return
+		}
+	}
+	if msg.Type == message.TypeSyn && m.autoRegister && msg.Window != 0 && ci.restartedBy(msg) {
+		if m.compatible(msg) {
+			m.readmit(ci, msg)
+		}
+		return
+	}
+	ci.touch()
+	select {
+	case <-m.recvTerm.Quit():
+	case ci.localControlRecvChan <- msg:
+	default:
+		// control messages are retransmitted so drop it if the connection is busy
+	}
+}
+
+// compatible reports whether the peer that sent syn offers the settings of the transport,
+// otherwise refusing it with a SYNACK carrying those settings and a zero window,
+// unless its source IP exceeds the rate limit
+func (m *Multiplexer) compatible(syn *message.AddressedMessage) bool {
+	err := m.cfg.checkOffer(syn)
+	if err == nil {
+		return true
+	}
+	m.counters.rejectedIncompatible.Add(1)
+	m.cfg.Logger.Warn("rejected connection request", "peer", syn.Addr, "err", err)
+	if !m.allow(syn) {
+		return false
+	}
+	refusal := message.NewControlMessage(syn.Addr, message.TypeSynAck, 0, 0)
+	m.cfg.offer(refusal)
+	select {
+	case <-m.recvTerm.Quit():
+	case m.sendChan <- refusal:
+	}
+	return false
+}
+
+// admit registers the peer that sent syn once it has proven its address by echoing a cookie.
+// Only requests that cost the server a reply or state are charged to the rate limit of their source IP,
+// so that spoofed SYNs cannot use up the limit of a peer that proves its address.
+func (m *Multiplexer) admit(syn *message.AddressedMessage) {
+	if m.cfg.SynCookies && !m.cookies.verify(syn.Addr, syn.SeqNo, syn.Window, syn.Cookie) {
+		if syn.Cookie != 0 {
+			// cookie was forged or expired, so challenge again
+			m.counters.rejectedCookie.Add(1)
+			m.cfg.Logger.Debug("rejected connection request with invalid cookie", "peer", syn.Addr)
 		}
+		m.challenge(syn)
+		return
+	}
+	if !m.cfg.SynCookies && !m.allow(syn) {
+		return
+	}
+	m.registerPeer(syn)
+}
+
+// allow charges syn to the rate limit of its source IP, reporting whether it is within the limit
+func (m *Multiplexer) allow(syn *message.AddressedMessage) bool {
+	if m.limiter.allow(syn.Addr.Addr()) {
+		return true
+	}
+	m.counters.rejectedRateLimit.Add(1)
+	m.cfg.Logger.Debug("rejected connection request over rate limit", "peer", syn.Addr)
+	return false
+}
+
+// readmit replaces ci with a connection for syn, which carries a new ISN, once the peer has proven its address.
+// Such a SYN comes from a restarted peer but may also be spoofed to reset the connection,
+// so it is challenged with a cookie even if SynCookies is disabled.
+func (m *Multiplexer) readmit(ci *connInfo, syn *message.AddressedMessage) {
+	if !m.cookies.verify(syn.Addr, syn.SeqNo, syn.Window, syn.Cookie) {
+		m.challenge(syn)
+		return
+	}
+	ci.logger.Info("peer restarted")
+	ci.fail(ErrPeerClosed)
+	m.registerPeer(syn)
+}
+
+// challenge answers syn with a cookie that the peer must repeat in its SYN to prove its address,
+// unless its source IP exceeds the rate limit
+func (m *Multiplexer) challenge(syn *message.AddressedMessage) {
+	if !m.allow(syn) {
+		return
+	}
+	m.counters.challenged.Add(1)
+	challenge := message.NewControlMessage(syn.Addr, message.TypeCookie, m.cookies.issue(syn.Addr, syn.SeqNo, syn.Window), 0)
+	select {
+	case <-m.recvTerm.Quit():
+	case m.sendChan <- challenge:
 	}
 }
 
-// registerAddress registers an address as a source/destination for messages
-func (m *Multiplexer) registerAddress(addr netip.AddrPort) {
+// registerPeer creates a connection in SYN_RCVD for the peer that sent syn
+// unless the server is at its peer cap
+func (m *Multiplexer) registerPeer(syn *message.AddressedMessage) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if _, ok := m.connInfos[addr]; ok {
+	if m.refusing {
 		return
 	}
-	localSenderRecvChan := make(chan *message.AddressedMessage, config.LocalSenderRecvChanBufferSize)
-	localReceiverRecvChan := make(chan *message.AddressedMessage, config.LocalReceiverRecvChanBufferSize)
-	localInputChan := make(chan rune, config.LocalInputChanBufferSize)
-	waitChan := make(chan rune, config.WaitChanBufferSize)
-	ci := &connInfo{
-		localSenderRecvChan:   localSenderRecvChan,
-		localReceiverRecvChan: localReceiverRecvChan,
-		localInputChan:        localInputChan,
-		waitChan:              waitChan,
-		sender:                NewSender(m.sendChan, localSenderRecvChan, localInputChan, addr),
-		receiver:              NewReceiver(m.sendChan, localReceiverRecvChan, m.outputChan, addr),
-	}
-	go ci.sender.Start()
-	go ci.receiver.Start()
-	go ci.runWaiter()
+	if m.cfg.MaxPeers > 0 && len(m.connInfos) >= m.cfg.MaxPeers {
+		m.counters.rejectedPeerLimit.Add(1)
+		m.cfg.Logger.Warn("rejected connection request at peer cap", "peer", syn.Addr, "max_peers", m.cfg.MaxPeers)
+		return
+	}
+	ci := newConnInfo(m, syn.Addr, stateSynReceived)
+	ci.peerISN = syn.SeqNo
+	ci.window = min(ci.window, uint32(syn.Window))
+	m.connInfos[syn.Addr] = ci
+	go ci.runControl()
+}
+
+// connect creates a connection in SYN_SENT to addr and waits for the handshake to complete
+func (m *Multiplexer) connect(ctx context.Context, addr netip.AddrPort) (*connInfo, error) {
+	m.mu.Lock()
+	if m.refusing {
+		m.mu.Unlock()
+		return nil, ErrTransportClosed
+	}
+	if _, ok := m.connInfos[addr]; ok {
+		m.mu.Unlock()
+		return nil, ErrAlreadyConnected
+	}
+	ci := newConnInfo(m, addr, stateSynSent)
 	m.connInfos[addr] = ci
+	go ci.runControl()
+	m.mu.Unlock()
+
+	select {
+	case <-ci.established:
+		return ci, nil
+	case <-ci.closed:
+		return nil, ci.err
+	case <-ctx.Done():
+		ci.requestClose()
+		return nil, ctx.Err()
+	}
+}
+
+// forget removes ci from the multiplexer if it is still registered
+func (m *Multiplexer) forget(ci *connInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.connInfos[ci.addr] == ci {
+		delete(m.connInfos, ci.addr)
+	}
+}
+
+// Metrics holds counters of a transport and its connections
+type Metrics struct {
+	ActivePeers          int    // peers with a connection in any state
+	EvictedPeers         uint64 // connections evicted after their idle timeout
+	Challenged           uint64 // connection requests answered with a cookie challenge
+	RejectedRateLimit    uint64 // connection requests dropped because their source IP exceeded the rate limit
+	RejectedCookie       uint64 // connection requests with a forged or expired cookie
+	RejectedPeerLimit    uint64 // connection requests dropped because the peer cap was reached
+	RejectedIncompatible uint64 // connection requests refused because they offered another protocol or sequence no. space
+	RejectedAuth         uint64 // datagrams dropped because they failed authentication with the pre-shared key
+	RejectedReplay       uint64 // authentic datagrams dropped because they were received before
+}
+
+// Metrics obtains the current counters
+func (m *Multiplexer) Metrics() Metrics {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return Metrics{
+		ActivePeers:          len(m.connInfos),
+		EvictedPeers:         m.counters.evicted.Load(),
+		Challenged:           m.counters.challenged.Load(),
+		RejectedRateLimit:    m.counters.rejectedRateLimit.Load(),
+		RejectedCookie:       m.counters.rejectedCookie.Load(),
+		RejectedPeerLimit:    m.counters.rejectedPeerLimit.Load(),
+		RejectedIncompatible: m.counters.rejectedIncompatible.Load(),
+	}
 }
 
 // loadConnInfo loads the connection info associated with addr
@@ -146,6 +345,15 @@ func (m *Multiplexer) loadConnInfo(addr netip.AddrPort) (*connInfo, bool) {
 	return ci, found
 }
 
+// connAttrs obtains the log attributes identifying the connection with addr, or none if there is none
+func (m *Multiplexer) connAttrs(addr netip.AddrPort) []slog.Attr {
+	ci, found := m.loadConnInfo(addr)
+	if !found {
+		return nil
+	}
+	return []slog.Attr{slog.Uint64("conn", ci.id)}
+}
+
 // loadAllConnInfos loads the connection infos associated with all addresses
 func (m *Multiplexer) loadAllConnInfos() []*connInfo {
 	m.mu.RLock()
@@ -156,21 +364,3 @@ func (m *Multiplexer) loadAllConnInfos() []*connInfo {
 	}
 	return cis
 }
-
-type connInfo struct {
-	localSenderRecvChan   chan *message.AddressedMessage
-	localReceiverRecvChan chan *message.AddressedMessage
-	localInputChan        chan rune
-	waitChan              chan rune
-	sender                *Sender
-	receiver              *Receiver
-}
-
-// runWaiter reads from waitChan
-// and forwards characters into localInputChan when sender is ready
-func (w *connInfo) runWaiter() {
-	for r := range w.waitChan {
-		w.sender.WaitForReady()
-		w.localInputChan <- r
-	}
-}
@@ -0,0 +1,186 @@
+package gbn
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"rdt/internal/message"
+	"rdt/internal/simnet"
+	"testing"
+	"time"
+)
+
+// newSyn creates a SYN from addr offering the settings of cfg
+func newSyn(cfg *Config, addr netip.AddrPort, isn uint32, cookie uint32) *message.AddressedMessage {
+	syn := message.NewControlMessage(addr, message.TypeSyn, isn, 32)
+	cfg.offer(syn)
+	syn.Cookie = cookie
+	return syn
+}
+
+func TestRestartNeedsCookie(t *testing.T) {
+	cfg := DefaultConfig()
+	// restarts are challenged even without cookies for new peers
+	cfg.SynCookies = false
+	client, server := newTestPair(t, cfg, simnet.Config{Delay: time.Millisecond})
+	_, s := dialAccept(t, client, server)
+	addr := s.RemoteAddr()
+	s.ci.mu.Lock()
+	isn := (s.ci.peerISN + 1) % cfg.MaxSeqNo
+	s.ci.mu.Unlock()
+
+	// a spoofed SYN with a new ISN only gets a challenge
+	server.mux.recvChan <- newSyn(&cfg, addr, isn, 0)
+	time.Sleep(50 * time.Millisecond)
+	if err := s.Err(); err != nil {
+		t.Fatal("connection closed by SYN without cookie:", err)
+	}
+	if challenged := server.Metrics().Challenged; challenged != 1 {
+		t.Fatalf("challenged %d SYNs, want 1", challenged)
+	}
+
+	// the restarted peer proves its address by repeating the cookie
+	server.mux.recvChan <- newSyn(&cfg, addr, isn, server.mux.cookies.issue(addr, isn, 32))
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("connection not replaced by SYN with cookie")
+	}
+	if err := s.Err(); !errors.Is(err, ErrPeerClosed) {
+		t.Fatalf("closed with %v, want %v", err, ErrPeerClosed)
+	}
+	// the connection is replaced once the old one finished
+	time.Sleep(50 * time.Millisecond)
+	ci, found := server.mux.loadConnInfo(addr)
+	if !found || ci == s.ci {
+		t.Fatal("no new connection for restarted peer")
+	}
+}
+
+func TestRateLimitSkipsCookieEcho(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PeerRateLimit = 0.001
+	cfg.PeerRateBurst = 1
+	_, server := newTestPair(t, cfg, simnet.Config{Delay: time.Millisecond})
+	addr := netip.MustParseAddrPort("[2001:db8::1]:4000")
+
+	// the challenge uses up the burst, so a spoofed SYN from the same IP is dropped
+	server.mux.recvChan <- newSyn(&cfg, addr, 7, 0)
+	server.mux.recvChan <- newSyn(&cfg, addr, 8, 0)
+	server.mux.recvChan <- newSyn(&cfg, addr, 7, server.mux.cookies.issue(addr, 7, 32))
+	time.Sleep(50 * time.Millisecond)
+
+	metrics := server.Metrics()
+	if metrics.Challenged != 1 || metrics.RejectedRateLimit != 1 {
+		t.Fatalf("challenged %d and rate limited %d SYNs, want 1 and 1", metrics.Challenged, metrics.RejectedRateLimit)
+	}
+	if metrics.ActivePeers != 1 {
+		t.Fatal("SYN with cookie not admitted over the rate limit")
+	}
+}
+
+func TestIncompatiblePeerRefused(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cfg *Config)
+	}{
+		{"mode", func(cfg *Config) { cfg.Mode = SelectiveRepeat }},
+		{"max seq no", func(cfg *Config) { cfg.MaxSeqNo = 2 * cfg.MaxSeqNo }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Logger = discardLogger()
+			n := simnet.New(simnet.Config{Delay: time.Millisecond})
+			go n.Start()
+			defer n.Stop()
+			sconn, err := n.Listen(netip.AddrPortFrom(netip.IPv6Unspecified(), serverAddr.Port()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cconn, err := n.Listen(netip.AddrPort{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, err := NewTransport(sconn, true, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.change(&cfg)
+			client, err := NewTransport(cconn, false, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server.Start()
+			client.Start()
+			defer server.Abort()
+			defer client.Abort()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err = client.Dial(ctx, serverAddr)
+			if !errors.Is(err, ErrIncompatiblePeer) {
+				t.Fatalf("dial failed with %v, want %v", err, ErrIncompatiblePeer)
+			}
+			if rejected := server.Metrics().RejectedIncompatible; rejected != 1 {
+				t.Fatalf("rejected %d connection requests, want 1", rejected)
+			}
+			if active := server.Metrics().ActivePeers; active != 0 {
+				t.Fatalf("%d active peers, want 0", active)
+			}
+		})
+	}
+}
+
+func TestSendToAndBroadcast(t *testing.T) {
+	cfg := DefaultConfig()
+	n, server := newTestServer(t, cfg, simnet.Config{Delay: time.Millisecond})
+	a, sa := dialAccept(t, newTestClient(t, n, cfg), server)
+	b, sb := dialAccept(t, newTestClient(t, n, cfg), server)
+	if sa.RemoteAddr() == sb.RemoteAddr() {
+		t.Fatalf("both peers connected from %v", sa.RemoteAddr())
+	}
+
+	expect := func(name string, conn *Conn, want string) {
+		t.Helper()
+		select {
+		case p := <-conn.OutputChan():
+			if string(p) != want {
+				t.Fatalf("%s received %q, want %q", name, p, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s did not receive %q", name, want)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// unicast only reaches the addressed peer
+	if err := server.SendTo(ctx, sa.RemoteAddr(), []byte("to a")); err != nil {
+		t.Fatal("send to a:", err)
+	}
+	if err := server.SendTo(ctx, sb.RemoteAddr(), []byte("to b")); err != nil {
+		t.Fatal("send to b:", err)
+	}
+	expect("a", a, "to a")
+	expect("b", b, "to b")
+
+	// broadcast reaches every peer once
+	if err := server.Broadcast(ctx, []byte("to all")); err != nil {
+		t.Fatal("broadcast:", err)
+	}
+	expect("a", a, "to all")
+	expect("b", b, "to all")
+	select {
+	case p := <-a.OutputChan():
+		t.Fatalf("a received extra payload %q", p)
+	case p := <-b.OutputChan():
+		t.Fatalf("b received extra payload %q", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unknown := netip.MustParseAddrPort("[::1]:1")
+	if err := server.SendTo(ctx, unknown, []byte("lost")); !errors.Is(err, ErrUnknownPeer) {
+		t.Fatalf("send to unknown peer failed with %v, want %v", err, ErrUnknownPeer)
+	}
+}
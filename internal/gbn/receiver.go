@@ -2,37 +2,51 @@ package gbn
 
 import (
 	"net/netip"
-	"rdt/internal/config"
 	"rdt/internal/message"
+	"rdt/internal/trace"
 	"rdt/internal/util"
 )
 
 // Receiver implements the "Go Back N" receiver protocol for pipelined reliable data transfer
 type Receiver struct {
+	cfg *Config // transport settings
 	// message transceiver fields
 	remoteAddr netip.AddrPort                   // address of sender
 	sendQueue  chan<- *message.AddressedMessage // outgoing message queue
 	recvQueue  <-chan *message.AddressedMessage // incoming message queue
 	// user recv field
-	outputChan chan<- rune // character output channel to user
+	outputChan chan<- []byte // payload output channel to user
 	// protocol data
-	expectedSeqNo uint32 // sequence no. of expected message
+	expectedSeqNo uint32            // sequence no. of expected message
+	rbuf          *receiveBuffer    // in-order payloads not yet read by user
+	stats         *connCounters     // counters of the connection
+	tracer        *trace.ConnTracer // records accepted and discarded messages
+	drain         *drainer          // completes once all payloads are delivered after the peer closed
 	// termination channels
 	term *util.Terminator
 }
 
 func NewReceiver(
+	cfg *Config,
 	sendQueue chan<- *message.AddressedMessage,
 	recvQueue <-chan *message.AddressedMessage,
-	outputChan chan<- rune,
+	outputChan chan<- []byte,
 	remoteAddr netip.AddrPort,
+	peerISN uint32,
+	stats *connCounters,
+	tracer *trace.ConnTracer,
 ) *Receiver {
 	return &Receiver{
+		cfg:           cfg,
 		remoteAddr:    remoteAddr,
 		sendQueue:     sendQueue,
 		recvQueue:     recvQueue,
 		outputChan:    outputChan,
-		expectedSeqNo: 0,
+		expectedSeqNo: peerISN,
+		rbuf:          newReceiveBuffer(cfg.ReceiveBufferSize),
+		stats:         stats,
+		tracer:        tracer,
+		drain:         newDrainer(),
 		term:          util.NewTerminator(),
 	}
 }
@@ -40,25 +54,57 @@ func NewReceiver(
 func (r *Receiver) Start() {
 	defer r.term.Done()
 	for {
+		// deliver buffered payloads while the user keeps up
+		outputChan, payload := r.rbuf.next(r.outputChan)
+		r.drain.update(r.rbuf.empty() && len(r.recvQueue) == 0)
+
 		select {
 		case <-r.term.Quit():
 			return
+		case <-r.drain.Requested():
+			r.drain.start()
+		case outputChan <- payload:
+			r.rbuf.pop()
+			if r.rbuf.reopened() {
+				// tell the sender it may resume
+				r.sendAck()
+			}
 		case msg := <-r.recvQueue:
-			if msg.SeqNo == r.expectedSeqNo {
-				// send output to user
-				r.outputChan <- msg.Char
+			r.stats.packetsReceived.Add(1)
+			// accept expected message only if there is room to buffer it
+			if msg.SeqNo == r.expectedSeqNo && r.rbuf.push(msg.Payload) {
+				r.stats.bytesReceived.Add(uint64(len(msg.Payload)))
+				r.tracer.Emit(trace.Event{Kind: trace.KindAccept, Seq: msg.SeqNo})
 				// increment expected sequence no.
 				r.expectedSeqNo++
-				r.expectedSeqNo %= config.MaxSeqNo
+				r.expectedSeqNo %= r.cfg.MaxSeqNo
+			} else {
+				r.stats.outOfOrderDrops.Add(1)
+				reason := "out_of_order"
+				if msg.SeqNo == r.expectedSeqNo {
+					reason = "buffer_full"
+				}
+				r.tracer.Emit(trace.Event{Kind: trace.KindDiscard, Seq: msg.SeqNo, Reason: reason})
 			}
-			// send ack
-			prevSeqNo := (r.expectedSeqNo - 1 + config.MaxSeqNo) % config.MaxSeqNo
-			ackMsg := message.NewAckMessage(r.remoteAddr, prevSeqNo)
-			r.sendQueue <- ackMsg
+			r.sendAck()
 		}
 	}
 }
 
+// sendAck acknowledges all messages before the expected one and advertises the free buffer space
+func (r *Receiver) sendAck() {
+	prevSeqNo := (r.expectedSeqNo - 1 + r.cfg.MaxSeqNo) % r.cfg.MaxSeqNo
+	ackMsg := message.NewAckMessage(r.remoteAddr, prevSeqNo, r.rbuf.window())
+	r.sendQueue <- ackMsg
+	r.stats.acksSent.Add(1)
+}
+
 func (r *Receiver) Stop() {
 	r.term.Terminate()
 }
+
+// Drain asks the receiver to deliver the payloads it buffered once those left in its recv channel are handled,
+// which must no longer be written to, returning a channel that is closed once all of them are delivered
+func (r *Receiver) Drain() <-chan struct{} {
+	return r.drain.request()
+}
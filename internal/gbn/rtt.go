@@ -0,0 +1,82 @@
+package gbn
+
+import (
+	"sync"
+	"time"
+)
+
+// RTTEstimator estimates the round trip time of a connection
+// and derives its retransmission timeout using the Jacobson/Karels algorithm (RFC 6298)
+type RTTEstimator struct {
+	mu        sync.Mutex
+	srtt      time.Duration // smoothed round trip time
+	rttvar    time.Duration // round trip time variation
+	rto       time.Duration // current retransmission timeout, including backoff
+	minRTO    time.Duration
+	maxRTO    time.Duration
+	hasSample bool
+	onSample  func(rtt time.Duration) // called with every sample if not nil
+}
+
+// NewRTTEstimator creates an estimator whose timeout starts at initial
+// and is always kept within [minRTO, maxRTO], passing every sample to onSample if not nil
+func NewRTTEstimator(initial, minRTO, maxRTO time.Duration, onSample func(rtt time.Duration)) *RTTEstimator {
+	return &RTTEstimator{
+		rto:      min(max(initial, minRTO), maxRTO),
+		minRTO:   minRTO,
+		maxRTO:   maxRTO,
+		onSample: onSample,
+	}
+}
+
+// Sample updates the estimates with a round trip time measured on a message that was never retransmitted
+func (e *RTTEstimator) Sample(rtt time.Duration) {
+	if e.onSample != nil {
+		e.onSample(rtt)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.hasSample {
+		e.srtt = rtt
+		e.rttvar = rtt / 2
+		e.hasSample = true
+	} else {
+		// rttvar = 3/4 rttvar + 1/4 |srtt - rtt|, srtt = 7/8 srtt + 1/8 rtt
+		diff := e.srtt - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		e.rttvar = (3*e.rttvar + diff) / 4
+		e.srtt = (7*e.srtt + rtt) / 8
+	}
+	// a fresh sample also clears any backoff
+	e.rto = min(max(e.srtt+4*e.rttvar, e.minRTO), e.maxRTO)
+}
+
+// Backoff doubles the retransmission timeout after a timeout, up to the maximum
+func (e *RTTEstimator) Backoff() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rto = min(2*e.rto, e.maxRTO)
+}
+
+// RTO obtains the current retransmission timeout
+func (e *RTTEstimator) RTO() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.rto
+}
+
+// SRTT obtains the smoothed round trip time, or zero if no sample has been taken
+func (e *RTTEstimator) SRTT() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.srtt
+}
+
+// RTTVar obtains the round trip time variation, or zero if no sample has been taken
+func (e *RTTEstimator) RTTVar() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.rttvar
+}
@@ -1,104 +1,232 @@
 package gbn
 
 import (
+	"log/slog"
 	"net/netip"
-	"rdt/internal/config"
+	"rdt/internal/clock"
 	"rdt/internal/message"
+	"rdt/internal/trace"
 	"rdt/internal/util"
+	"time"
 )
 
 // Sender implements the "Go Back N" sender protocol for pipelined reliable data transfer
 type Sender struct {
+	cfg *Config // transport settings
 	// message transceiver fields
 	remoteAddr netip.AddrPort                   // address of receiver
 	sendQueue  chan<- *message.AddressedMessage // outgoing message queue
 	recvQueue  <-chan *message.AddressedMessage // incoming message queue
 	// user send fields
-	inputChan <-chan rune   // character input channel from user
-	sem       chan struct{} // semaphore for signaling window availability
+	inputChan <-chan []byte // payload input channel from user, only read while the window has room
 	// protocol data
-	baseSeqNo uint32        // sequence no. of last unacked message
-	nextSeqNo uint32        // next sequence no. available to send
-	buf       []rune        // buffer for unacked messages
-	timeout   *TimeoutTimer // retransmission timer
+	window       uint32               // number of messages that may be unacked at once
+	baseSeqNo    uint32               // sequence no. of last unacked message
+	baseSlot     uint32               // buffer slot of the message at baseSeqNo
+	nextSeqNo    uint32               // next sequence no. available to send
+	buf          [][]byte             // buffer for unacked messages
+	sentAt       []time.Time          // time each unacked message was first sent
+	resent       []bool               // flags for unacked messages that were retransmitted
+	timeout      *TimeoutTimer        // retransmission timer
+	rtt          *RTTEstimator        // source of the retransmission timeout
+	dupAcks      int                  // consecutive duplicate acks for the message before baseSeqNo
+	cc           CongestionController // source of the congestion window
+	rwnd         uint32               // receive window last advertised by the receiver
+	prober       *windowProber        // probes the receiver while its window is closed
+	drain        *drainer             // completes once all payloads are acked after closing
+	stats        *connCounters        // counters of the connection
+	logger       *slog.Logger         // logs timeouts and retransmissions at debug level
+	tracer       *trace.ConnTracer    // records window, timer and retransmission decisions
+	inRecovery   bool                 // flag that indicates a loss is being recovered from
+	recoverSeqNo uint32               // sequence no. that ends loss recovery once acked
 	// termination channels
 	term *util.Terminator
 }
 
 func NewSender(
+	cfg *Config,
 	sendQueue chan<- *message.AddressedMessage,
 	recvQueue <-chan *message.AddressedMessage,
-	inputChan <-chan rune,
+	inputChan <-chan []byte,
 	remoteAddr netip.AddrPort,
+	isn uint32,
+	window uint32,
+	rtt *RTTEstimator,
+	cc CongestionController,
+	stats *connCounters,
+	logger *slog.Logger,
+	tracer *trace.ConnTracer,
 ) *Sender {
 	return &Sender{
+		cfg:        cfg,
 		remoteAddr: remoteAddr,
 		sendQueue:  sendQueue,
 		recvQueue:  recvQueue,
 		inputChan:  inputChan,
-		sem:        make(chan struct{}, config.WindowSize),
-		baseSeqNo:  0,
-		nextSeqNo:  0,
-		buf:        make([]rune, config.WindowSize),
-		timeout:    NewTimeoutTimer(config.GBNWriteTimeout),
+		window:     window,
+		baseSeqNo:  isn,
+		nextSeqNo:  isn,
+		buf:        make([][]byte, window),
+		sentAt:     make([]time.Time, window),
+		resent:     make([]bool, window),
+		timeout:    NewTimeoutTimer(cfg.Clock, rtt.RTO()),
+		rtt:        rtt,
+		cc:         cc,
+		rwnd:       window,
+		prober:     newWindowProber(cfg),
+		drain:      newDrainer(),
+		stats:      stats,
+		logger:     logger,
+		tracer:     tracer,
 		term:       util.NewTerminator(),
 	}
 }
 
 func (s *Sender) Start() {
 	defer s.term.Done()
+	defer s.prober.timer.Stop()
 	for {
+		// only accept new payloads while the effective window has room
+		var inputChan <-chan []byte
+		if s.inFlight() < min(s.cc.Window(), s.window, s.rwnd) {
+			inputChan = s.inputChan
+		}
+		s.prober.update(s.rwnd, s.inFlight(), s.rtt.RTO())
+		s.drain.update(s.inFlight() == 0 && len(s.inputChan) == 0)
+		s.stats.window(s.inFlight(), min(s.cc.Window(), s.window, s.rwnd))
+
 		select {
 		case <-s.term.Quit():
 			return
 		case msg := <-s.recvQueue:
+			s.stats.acksReceived.Add(1)
+			// honour the receive window advertised by the receiver
+			windowUpdate := uint32(msg.Window) != s.rwnd
+			s.rwnd = uint32(msg.Window)
+			// ignore acks for messages outside of the window
+			newBaseSeqNo := (msg.SeqNo + 1) % s.cfg.MaxSeqNo
+			windowShift := (newBaseSeqNo - s.baseSeqNo + s.cfg.MaxSeqNo) % s.cfg.MaxSeqNo
+			inFlight := s.inFlight()
+			if windowShift == 0 && inFlight > 0 && !windowUpdate && s.rwnd > 0 {
+				// receiver got a message out of order, so the one at baseSeqNo is likely lost
+				s.stats.dupAcks.Add(1)
+				s.dupAcks++
+				if s.dupAcks == s.cfg.DupAckThreshold {
+					s.fastRetransmit()
+				}
+				continue
+			}
+			if windowShift == 0 || windowShift > inFlight {
+				continue
+			}
+			s.dupAcks = 0
+			// sample round trip time unless the message was retransmitted (Karn's rule)
+			if idx := s.slot(msg.SeqNo); !s.resent[idx] {
+				s.rtt.Sample(clock.Since(s.cfg.Clock, s.sentAt[idx]))
+			}
+			// grow congestion window unless recovering from a loss
+			if s.inRecovery {
+				recovered := (s.recoverSeqNo - s.baseSeqNo + s.cfg.MaxSeqNo) % s.cfg.MaxSeqNo
+				s.inRecovery = windowShift < recovered
+			} else {
+				s.cc.OnAck(windowShift, inFlight, s.window)
+			}
 			// shift window
-			newBaseSeqNo := (msg.SeqNo + 1) % config.MaxSeqNo
-			windowShift := (newBaseSeqNo - s.baseSeqNo + config.MaxSeqNo) % config.MaxSeqNo
 			s.baseSeqNo = newBaseSeqNo
-			// signal availability for new messages
-			for i := uint32(0); i < windowShift; i++ {
-				<-s.sem
-			}
+			s.baseSlot = (s.baseSlot + windowShift) % s.window
+			s.tracer.Emit(trace.Event{Kind: trace.KindWindow, Seq: s.baseSeqNo, InFlight: s.inFlight(), Window: min(s.cc.Window(), s.window, s.rwnd)})
 
 			// reset timer for oldest unacked message
 			if s.baseSeqNo == s.nextSeqNo {
 				s.timeout.Stop()
+				s.tracer.Emit(trace.Event{Kind: trace.KindTimerStop, Seq: msg.SeqNo})
 			} else {
-				s.timeout.Start()
+				s.startTimer()
 			}
-		case char := <-s.inputChan:
-			// store character in buffer
-			s.buf[s.nextSeqNo%config.WindowSize] = char
+		case payload := <-inputChan:
+			// store payload in buffer
+			idx := s.slot(s.nextSeqNo)
+			s.buf[idx] = payload
+			s.sentAt[idx] = s.cfg.Clock.Now()
+			s.resent[idx] = false
 			// send data
-			msg := message.NewDataMessage(s.remoteAddr, s.nextSeqNo, char)
+			msg := message.NewDataMessage(s.remoteAddr, s.nextSeqNo, payload)
 			s.sendQueue <- msg
+			s.stats.sent(payload, false)
+			s.tracer.Emit(trace.Event{Kind: trace.KindSend, Seq: s.nextSeqNo, InFlight: s.inFlight() + 1})
 			if s.baseSeqNo == s.nextSeqNo {
 				// start timer for oldest unacked message
-				s.timeout.Start()
+				s.startTimer()
 			}
 			// increment next sequence no.
-			s.nextSeqNo = (s.nextSeqNo + 1) % config.MaxSeqNo
+			s.nextSeqNo = (s.nextSeqNo + 1) % s.cfg.MaxSeqNo
 		case <-s.timeout.Channel():
-			// restart timer
-			s.timeout.Start()
-			// resend all unacked messages
-			for i := s.baseSeqNo; i != s.nextSeqNo; i = (i + 1) % config.MaxSeqNo {
-				char := s.buf[i%config.WindowSize]
-				msg := message.NewDataMessage(s.remoteAddr, i, char)
-				s.sendQueue <- msg
-			}
+			// shrink congestion window, back off and restart timer
+			s.stats.timeouts.Add(1)
+			s.logger.Debug("retransmission timeout", "seq", s.baseSeqNo, "in_flight", s.inFlight(), "rto", s.rtt.RTO())
+			s.tracer.Emit(trace.Event{Kind: trace.KindTimerExpire, Seq: s.baseSeqNo, RTO: s.rtt.RTO()})
+			s.cc.OnTimeout(s.inFlight())
+			s.inRecovery = false
+			s.rtt.Backoff()
+			s.startTimer()
+			s.dupAcks = 0
+			s.resendAll("timeout")
+		case <-s.drain.Requested():
+			s.drain.start()
+		case <-s.prober.Channel():
+			s.prober.probe(s.sendQueue, s.remoteAddr, s.baseSeqNo)
 		}
 	}
 }
 
 func (s *Sender) Stop() {
 	s.term.Terminate()
-	close(s.sem)
 }
 
-// WaitForReady waits until s has available room in its window
-// to send new messages
-func (s *Sender) WaitForReady() {
-	s.sem <- struct{}{}
+// Drain asks the sender to send the payloads left in its input channel,
+// which must no longer be written to, returning a channel that is closed once all of them are acked
+func (s *Sender) Drain() <-chan struct{} {
+	return s.drain.request()
+}
+
+// slot obtains the buffer slot of seqNo within the window,
+// counted from the slot of baseSeqNo so that it is correct for any sequence no. space
+func (s *Sender) slot(seqNo uint32) uint32 {
+	return (s.baseSlot + (seqNo-s.baseSeqNo+s.cfg.MaxSeqNo)%s.cfg.MaxSeqNo) % s.window
+}
+
+// inFlight obtains the number of sent but unacked messages
+func (s *Sender) inFlight() uint32 {
+	return (s.nextSeqNo - s.baseSeqNo + s.cfg.MaxSeqNo) % s.cfg.MaxSeqNo
+}
+
+// fastRetransmit resends all unacked messages without waiting for the timeout
+func (s *Sender) fastRetransmit() {
+	s.logger.Debug("fast retransmit", "seq", s.baseSeqNo, "in_flight", s.inFlight())
+	if !s.inRecovery {
+		s.cc.OnLoss(s.inFlight())
+		s.inRecovery = true
+		s.recoverSeqNo = s.nextSeqNo
+	}
+	s.startTimer()
+	s.resendAll("dup_ack")
+}
+
+// startTimer (re)starts the retransmission timer of the oldest unacked message
+func (s *Sender) startTimer() {
+	s.timeout.SetDuration(s.rtt.RTO())
+	s.timeout.Start()
+	s.tracer.Emit(trace.Event{Kind: trace.KindTimerStart, Seq: s.baseSeqNo, RTO: s.rtt.RTO()})
+}
+
+// resendAll resends all unacked messages starting from baseSeqNo, traced as a single burst caused by reason
+func (s *Sender) resendAll(reason string) {
+	s.tracer.Emit(trace.Event{Kind: trace.KindRetransmit, Seq: s.baseSeqNo, Count: s.inFlight(), InFlight: s.inFlight(), Reason: reason})
+	for i := s.baseSeqNo; i != s.nextSeqNo; i = (i + 1) % s.cfg.MaxSeqNo {
+		s.resent[s.slot(i)] = true
+		payload := s.buf[s.slot(i)]
+		msg := message.NewDataMessage(s.remoteAddr, i, payload)
+		s.sendQueue <- msg
+		s.stats.sent(payload, true)
+	}
 }
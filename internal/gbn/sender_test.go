@@ -0,0 +1,306 @@
+package gbn
+
+import (
+	"net/netip"
+	"rdt/internal/clock"
+	"rdt/internal/message"
+	"testing"
+	"time"
+)
+
+var peerAddr = netip.MustParseAddrPort("[::1]:4000")
+
+// senderModes creates the sender of each mode along with the ack for seqNo it expects
+var senderModes = []struct {
+	mode      Mode
+	newSender func(cfg *Config, sendQueue chan<- *message.AddressedMessage, recvQueue <-chan *message.AddressedMessage, inputChan <-chan []byte, rtt *RTTEstimator) dataSender
+	ack       func(seqNo uint32) *message.AddressedMessage
+}{
+	{
+		GoBackN,
+		func(cfg *Config, sendQueue chan<- *message.AddressedMessage, recvQueue <-chan *message.AddressedMessage, inputChan <-chan []byte, rtt *RTTEstimator) dataSender {
+			return NewSender(cfg, sendQueue, recvQueue, inputChan, peerAddr, 0, 8, rtt, FixedWindow{}, &connCounters{}, discardLogger(), nil)
+		},
+		func(seqNo uint32) *message.AddressedMessage { return message.NewAckMessage(peerAddr, seqNo, 8) },
+	},
+	{
+		SelectiveRepeat,
+		func(cfg *Config, sendQueue chan<- *message.AddressedMessage, recvQueue <-chan *message.AddressedMessage, inputChan <-chan []byte, rtt *RTTEstimator) dataSender {
+			return NewSRSender(cfg, sendQueue, recvQueue, inputChan, peerAddr, 0, 8, rtt, FixedWindow{}, &connCounters{}, discardLogger(), nil)
+		},
+		func(seqNo uint32) *message.AddressedMessage {
+			return message.NewSelectiveAckMessage(peerAddr, seqNo, 8)
+		},
+	},
+}
+
+// TestRetransmissionTimeout checks that an unacked message is resent each time the timeout expires,
+// that the timeout doubles up to its maximum, and that acks of resent messages are not sampled (Karn's rule)
+func TestRetransmissionTimeout(t *testing.T) {
+	for _, sm := range senderModes {
+		t.Run(sm.mode.String(), func(t *testing.T) {
+			clk := clock.NewFake(time.Unix(0, 0))
+			cfg := DefaultConfig()
+			cfg.Clock = clk
+			cfg.InitialRTO = time.Second
+			cfg.MaxRTO = 4 * time.Second
+			sendQueue := make(chan *message.AddressedMessage, 8)
+			recvQueue := make(chan *message.AddressedMessage)
+			inputChan := make(chan []byte, 1)
+			rtt := NewRTTEstimator(cfg.InitialRTO, cfg.MinRTO, cfg.MaxRTO, nil)
+			s := sm.newSender(&cfg, sendQueue, recvQueue, inputChan, rtt)
+			go s.Start()
+			defer s.Stop()
+
+			expectData := func(seqNo uint32) {
+				t.Helper()
+				select {
+				case msg := <-sendQueue:
+					if msg.Type != message.TypeData || msg.SeqNo != seqNo {
+						t.Fatalf("sent %v %d, want DATA %d", msg.Type, msg.SeqNo, seqNo)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("DATA %d not sent", seqNo)
+				}
+			}
+			timerArmed := func() bool { return clk.Pending() == 1 }
+
+			inputChan <- []byte("a")
+			expectData(0)
+			for _, want := range []time.Duration{2 * time.Second, 4 * time.Second, 4 * time.Second} {
+				waitFor(t, "retransmission timer", timerArmed)
+				// nothing is resent just before the timeout
+				clk.Advance(rtt.RTO() - time.Millisecond)
+				select {
+				case msg := <-sendQueue:
+					t.Fatalf("resent %d before the timeout", msg.SeqNo)
+				case <-time.After(10 * time.Millisecond):
+				}
+				clk.Advance(time.Millisecond)
+				expectData(0)
+				if rto := rtt.RTO(); rto != want {
+					t.Fatalf("backed off to %v, want %v", rto, want)
+				}
+			}
+
+			// the ack of the resent message gives no sample
+			waitFor(t, "retransmission timer", timerArmed)
+			clk.Advance(100 * time.Millisecond)
+			recvQueue <- sm.ack(0)
+			waitFor(t, "timer stopped by ack", func() bool { return clk.Pending() == 0 })
+			if srtt := rtt.SRTT(); srtt != 0 {
+				t.Fatalf("sampled %v from a resent message", srtt)
+			}
+
+			// the ack of a message sent once gives a sample and clears the backoff
+			inputChan <- []byte("b")
+			expectData(1)
+			waitFor(t, "retransmission timer", timerArmed)
+			clk.Advance(100 * time.Millisecond)
+			recvQueue <- sm.ack(1)
+			// a sample of the resent message would have left a different estimate
+			waitFor(t, "round trip time sample", func() bool { return rtt.SRTT() != 0 })
+			if srtt, rto := rtt.SRTT(), rtt.RTO(); srtt != 100*time.Millisecond || rto != 300*time.Millisecond {
+				t.Fatalf("sampled srtt %v and rto %v, want 100ms and 300ms", srtt, rto)
+			}
+		})
+	}
+}
+
+// TestGBNFastRetransmit checks that Go-Back-N resends the window after DupAckThreshold duplicate acks
+// of the message before a lost one, before its retransmission timeout expires
+func TestGBNFastRetransmit(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	cfg := DefaultConfig()
+	cfg.Clock = clk
+	sendQueue := make(chan *message.AddressedMessage, 16)
+	recvQueue := make(chan *message.AddressedMessage)
+	inputChan := make(chan []byte, 4)
+	rtt := NewRTTEstimator(cfg.InitialRTO, cfg.MinRTO, cfg.MaxRTO, nil)
+	counters := &connCounters{}
+	s := NewSender(&cfg, sendQueue, recvQueue, inputChan, peerAddr, 0, 8, rtt, FixedWindow{}, counters, discardLogger(), nil)
+	go s.Start()
+	defer s.Stop()
+
+	const sent = 4
+	for range sent {
+		inputChan <- []byte("a")
+	}
+	for range sent {
+		<-sendQueue
+	}
+	waitFor(t, "retransmission timer", func() bool { return clk.Pending() == 1 })
+	clk.Advance(cfg.InitialRTO - time.Millisecond)
+
+	// message 0 was lost, so the receiver acks the sequence no. before it for each later message
+	dupAck := message.NewAckMessage(peerAddr, cfg.MaxSeqNo-1, 8)
+	for range cfg.DupAckThreshold - 1 {
+		recvQueue <- dupAck
+	}
+	select {
+	case msg := <-sendQueue:
+		t.Fatalf("resent %d after fewer than %d duplicate acks", msg.SeqNo, cfg.DupAckThreshold)
+	case <-time.After(10 * time.Millisecond):
+	}
+	recvQueue <- dupAck
+	for seqNo := range uint32(sent) {
+		select {
+		case msg := <-sendQueue:
+			if msg.SeqNo != seqNo {
+				t.Fatalf("resent %d, want %d", msg.SeqNo, seqNo)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d not resent after %d duplicate acks", seqNo, cfg.DupAckThreshold)
+		}
+	}
+
+	// the fast retransmit restarted the timer, so the original timeout passes without a resend
+	clk.Advance(time.Millisecond)
+	select {
+	case msg := <-sendQueue:
+		t.Fatalf("resent %d on the timeout of the first transmission", msg.SeqNo)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if timeouts := counters.timeouts.Load(); timeouts != 0 {
+		t.Fatalf("counted %d timeouts, want 0", timeouts)
+	}
+}
+
+// TestSRTimeoutsOfOneWindowBackOffOnce checks that the timeouts of several messages lost from one window
+// double the timeout and shrink the congestion window only once
+func TestSRTimeoutsOfOneWindowBackOffOnce(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	cfg := DefaultConfig()
+	cfg.Clock = clk
+	cfg.InitialRTO = time.Second
+	sendQueue := make(chan *message.AddressedMessage, 8)
+	inputChan := make(chan []byte, 4)
+	rtt := NewRTTEstimator(cfg.InitialRTO, cfg.MinRTO, cfg.MaxRTO, nil)
+	cc := NewNewReno(8)
+	s := NewSRSender(&cfg, sendQueue, make(chan *message.AddressedMessage), inputChan, peerAddr, 0, 8, rtt, cc, &connCounters{}, discardLogger(), nil)
+	go s.Start()
+	defer s.Stop()
+
+	const lost = 4
+	for range lost {
+		inputChan <- []byte("a")
+	}
+	for seqNo := range uint32(lost) {
+		if msg := <-sendQueue; msg.SeqNo != seqNo {
+			t.Fatalf("sent %d, want %d", msg.SeqNo, seqNo)
+		}
+	}
+	waitFor(t, "retransmission timers", func() bool { return clk.Pending() == lost })
+	clk.Advance(cfg.InitialRTO)
+	for range lost {
+		select {
+		case <-sendQueue:
+		case <-time.After(time.Second):
+			t.Fatal("expired message not resent")
+		}
+	}
+	if rto := rtt.RTO(); rto != 2*cfg.InitialRTO {
+		t.Fatalf("backed off to %v, want %v", rto, 2*cfg.InitialRTO)
+	}
+	if cwnd, ssthresh := cc.Window(), cc.SSThresh(); cwnd != 1 || ssthresh != lost/2 {
+		t.Fatalf("congestion window %d and ssthresh %d, want 1 and %d", cwnd, ssthresh, lost/2)
+	}
+}
+
+// TestSRAcks checks that a cumulative ack covers the messages whose acks were lost,
+// and that the oldest message is resent once enough later messages are acked
+func TestSRAcks(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	cfg := DefaultConfig()
+	cfg.Clock = clk
+	sendQueue := make(chan *message.AddressedMessage, 8)
+	recvQueue := make(chan *message.AddressedMessage)
+	inputChan := make(chan []byte, 8)
+	rtt := NewRTTEstimator(cfg.InitialRTO, cfg.MinRTO, cfg.MaxRTO, nil)
+	s := NewSRSender(&cfg, sendQueue, recvQueue, inputChan, peerAddr, 0, 8, rtt, FixedWindow{}, &connCounters{}, discardLogger(), nil)
+	go s.Start()
+	defer s.Stop()
+
+	for range 7 {
+		inputChan <- []byte("a")
+	}
+	for range 7 {
+		<-sendQueue
+	}
+	recvQueue <- message.NewAckMessage(peerAddr, 2, 8)
+	waitFor(t, "timers stopped by cumulative ack", func() bool { return clk.Pending() == 4 })
+
+	for seqNo := range uint32(cfg.DupAckThreshold) {
+		recvQueue <- message.NewSelectiveAckMessage(peerAddr, 4+seqNo, 8)
+	}
+	select {
+	case msg := <-sendQueue:
+		if msg.SeqNo != 3 {
+			t.Fatalf("resent %d, want 3", msg.SeqNo)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("oldest message not resent before its timeout")
+	}
+}
+
+// TestZeroWindowProbing checks that a sender stops sending while the receiver advertises a zero window,
+// probes it at a backed off interval and resumes once an ack reopens the window
+func TestZeroWindowProbing(t *testing.T) {
+	for _, sm := range senderModes {
+		t.Run(sm.mode.String(), func(t *testing.T) {
+			clk := clock.NewFake(time.Unix(0, 0))
+			cfg := DefaultConfig()
+			cfg.Clock = clk
+			sendQueue := make(chan *message.AddressedMessage, 8)
+			recvQueue := make(chan *message.AddressedMessage)
+			inputChan := make(chan []byte, 1)
+			rtt := NewRTTEstimator(cfg.InitialRTO, cfg.MinRTO, cfg.MaxRTO, nil)
+			s := sm.newSender(&cfg, sendQueue, recvQueue, inputChan, rtt)
+			go s.Start()
+			defer s.Stop()
+
+			expectData := func(seqNo uint32, payload string) {
+				t.Helper()
+				select {
+				case msg := <-sendQueue:
+					if msg.Type != message.TypeData || msg.SeqNo != seqNo || string(msg.Payload) != payload {
+						t.Fatalf("sent %v %d %q, want DATA %d %q", msg.Type, msg.SeqNo, msg.Payload, seqNo, payload)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("DATA %d %q not sent", seqNo, payload)
+				}
+			}
+			expectNothing := func(what string) {
+				t.Helper()
+				select {
+				case msg := <-sendQueue:
+					t.Fatalf("sent %v %d %s", msg.Type, msg.SeqNo, what)
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+
+			inputChan <- []byte("a")
+			expectData(0, "a")
+			// the second ack is only taken once the sender handled the first one and armed the probe timer
+			recvQueue <- message.NewAckMessage(peerAddr, 0, 0)
+			recvQueue <- message.NewAckMessage(peerAddr, 0, 0)
+			inputChan <- []byte("b")
+			expectNothing("while the window is closed")
+
+			interval := rtt.RTO()
+			for range 2 {
+				waitFor(t, "probe timer", func() bool { return clk.Pending() == 1 })
+				clk.Advance(interval - time.Millisecond)
+				expectNothing("before the probe is due")
+				clk.Advance(time.Millisecond)
+				// the probe repeats the acked message before the window without a payload
+				expectData(0, "")
+				interval *= 2
+			}
+
+			// the ack answering the probe reopens the window
+			recvQueue <- message.NewAckMessage(peerAddr, 0, 8)
+			expectData(1, "b")
+		})
+	}
+}
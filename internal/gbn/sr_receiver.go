@@ -0,0 +1,141 @@
+package gbn
+
+import (
+	"net/netip"
+	"rdt/internal/message"
+	"rdt/internal/trace"
+	"rdt/internal/util"
+)
+
+// SRReceiver implements the "Selective Repeat" receiver protocol for pipelined reliable data transfer
+type SRReceiver struct {
+	cfg *Config // transport settings
+	// message transceiver fields
+	remoteAddr netip.AddrPort                   // address of sender
+	sendQueue  chan<- *message.AddressedMessage // outgoing message queue
+	recvQueue  <-chan *message.AddressedMessage // incoming message queue
+	// user recv field
+	outputChan chan<- []byte // payload output channel to user
+	// protocol data
+	window    uint32            // number of messages that may be buffered out of order
+	baseSeqNo uint32            // sequence no. of oldest message not yet delivered
+	baseSlot  uint32            // buffer slot of the message at baseSeqNo
+	buf       [][]byte          // buffer for messages received out of order
+	received  []bool            // flags for buffered messages
+	rbuf      *receiveBuffer    // in-order payloads not yet read by user
+	stats     *connCounters     // counters of the connection
+	tracer    *trace.ConnTracer // records accepted and discarded messages
+	drain     *drainer          // completes once all payloads are delivered after the peer closed
+	// termination channels
+	term *util.Terminator
+}
+
+func NewSRReceiver(
+	cfg *Config,
+	sendQueue chan<- *message.AddressedMessage,
+	recvQueue <-chan *message.AddressedMessage,
+	outputChan chan<- []byte,
+	remoteAddr netip.AddrPort,
+	peerISN uint32,
+	window uint32,
+	stats *connCounters,
+	tracer *trace.ConnTracer,
+) *SRReceiver {
+	return &SRReceiver{
+		cfg:        cfg,
+		remoteAddr: remoteAddr,
+		sendQueue:  sendQueue,
+		recvQueue:  recvQueue,
+		outputChan: outputChan,
+		window:     window,
+		baseSeqNo:  peerISN,
+		buf:        make([][]byte, window),
+		received:   make([]bool, window),
+		rbuf:       newReceiveBuffer(cfg.ReceiveBufferSize),
+		stats:      stats,
+		tracer:     tracer,
+		drain:      newDrainer(),
+		term:       util.NewTerminator(),
+	}
+}
+
+func (r *SRReceiver) Start() {
+	defer r.term.Done()
+	for {
+		// deliver buffered payloads while the user keeps up
+		outputChan, payload := r.rbuf.next(r.outputChan)
+		r.drain.update(r.rbuf.empty() && len(r.recvQueue) == 0)
+
+		select {
+		case <-r.term.Quit():
+			return
+		case <-r.drain.Requested():
+			r.drain.start()
+		case outputChan <- payload:
+			r.rbuf.pop()
+			r.slide()
+			if r.rbuf.reopened() {
+				// tell the sender it may resume with an ack it has already seen
+				prevSeqNo := (r.baseSeqNo - 1 + r.cfg.MaxSeqNo) % r.cfg.MaxSeqNo
+				r.sendAck(prevSeqNo)
+			}
+		case msg := <-r.recvQueue:
+			offset := (msg.SeqNo - r.baseSeqNo + r.cfg.MaxSeqNo) % r.cfg.MaxSeqNo
+			lag := (r.baseSeqNo - msg.SeqNo + r.cfg.MaxSeqNo) % r.cfg.MaxSeqNo
+			r.stats.packetsReceived.Add(1)
+			if idx := (r.baseSlot + offset) % r.window; offset < r.window && !r.received[idx] {
+				// buffer message within receive window
+				r.stats.bytesReceived.Add(uint64(len(msg.Payload)))
+				r.buf[idx] = msg.Payload
+				r.received[idx] = true
+				r.tracer.Emit(trace.Event{Kind: trace.KindAccept, Seq: msg.SeqNo})
+				r.slide()
+			} else if offset >= r.window && lag > r.window {
+				// ignore message outside of current and previous window
+				r.stats.outOfOrderDrops.Add(1)
+				r.tracer.Emit(trace.Event{Kind: trace.KindDiscard, Seq: msg.SeqNo, Reason: "outside_window"})
+				continue
+			} else {
+				// duplicate of a message already received
+				r.stats.outOfOrderDrops.Add(1)
+				r.tracer.Emit(trace.Event{Kind: trace.KindDiscard, Seq: msg.SeqNo, Reason: "duplicate"})
+			}
+			r.sendAck(msg.SeqNo)
+		}
+	}
+}
+
+// slide moves in-order messages to the receive buffer while it has room
+func (r *SRReceiver) slide() {
+	for r.received[r.baseSlot] && r.rbuf.push(r.buf[r.baseSlot]) {
+		r.received[r.baseSlot] = false
+		r.buf[r.baseSlot] = nil
+		r.baseSeqNo = (r.baseSeqNo + 1) % r.cfg.MaxSeqNo
+		r.baseSlot = (r.baseSlot + 1) % r.window
+	}
+}
+
+// sendAck acknowledges the message with seqNo and advertises the free buffer space.
+// Once all messages up to seqNo were received, the ack is cumulative up to the last of them,
+// so that it stands in for acks that were lost, and otherwise it covers only seqNo.
+func (r *SRReceiver) sendAck(seqNo uint32) {
+	var ackMsg *message.AddressedMessage
+	if lag := (r.baseSeqNo - seqNo + r.cfg.MaxSeqNo) % r.cfg.MaxSeqNo; lag > 0 && lag <= r.window {
+		prevSeqNo := (r.baseSeqNo - 1 + r.cfg.MaxSeqNo) % r.cfg.MaxSeqNo
+		ackMsg = message.NewAckMessage(r.remoteAddr, prevSeqNo, r.rbuf.window())
+	} else {
+		ackMsg = message.NewSelectiveAckMessage(r.remoteAddr, seqNo, r.rbuf.window())
+	}
+	r.sendQueue <- ackMsg
+	r.stats.acksSent.Add(1)
+}
+
+func (r *SRReceiver) Stop() {
+	r.term.Terminate()
+}
+
+// Drain asks the receiver to deliver the payloads it buffered once those left in its recv channel are handled,
+// which must no longer be written to, returning a channel that is closed once all of them are delivered
+func (r *SRReceiver) Drain() <-chan struct{} {
+	return r.drain.request()
+}
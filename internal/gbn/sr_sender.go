@@ -0,0 +1,277 @@
+package gbn
+
+import (
+	"log/slog"
+	"net/netip"
+	"rdt/internal/clock"
+	"rdt/internal/message"
+	"rdt/internal/trace"
+	"rdt/internal/util"
+	"time"
+)
+
+// SRSender implements the "Selective Repeat" sender protocol for pipelined reliable data transfer
+type SRSender struct {
+	cfg *Config // transport settings
+	// message transceiver fields
+	remoteAddr netip.AddrPort                   // address of receiver
+	sendQueue  chan<- *message.AddressedMessage // outgoing message queue
+	recvQueue  <-chan *message.AddressedMessage // incoming message queue
+	// user send fields
+	inputChan <-chan []byte // payload input channel from user, only read while the window has room
+	// protocol data
+	window       uint32               // number of messages that may be unacked at once
+	baseSeqNo    uint32               // sequence no. of last unacked message
+	baseSlot     uint32               // buffer slot of the message at baseSeqNo
+	nextSeqNo    uint32               // next sequence no. available to send
+	buf          [][]byte             // buffer for unacked messages
+	acked        []bool               // flags for messages acked out of order
+	sentAt       []time.Time          // time each unacked message was first sent
+	resent       []bool               // flags for unacked messages that were retransmitted
+	rtt          *RTTEstimator        // source of the retransmission timeout
+	cc           CongestionController // source of the congestion window
+	rwnd         uint32               // receive window last advertised by the receiver
+	prober       *windowProber        // probes the receiver while its window is closed
+	drain        *drainer             // completes once all payloads are acked after closing
+	stats        *connCounters        // counters of the connection
+	logger       *slog.Logger         // logs timeouts and retransmissions at debug level
+	tracer       *trace.ConnTracer    // records window, timer and retransmission decisions
+	inRecovery   bool                 // flag that indicates a loss is being recovered from
+	recoverSeqNo uint32               // sequence no. that ends loss recovery once the window slides past it
+	laterAcks    int                  // acks of messages after baseSeqNo since the window last slid
+	timers       []clock.Timer        // retransmission timer per message
+	timeoutChan  chan uint32          // sequence nos. of messages whose timer expired
+	// termination channels
+	term *util.Terminator
+}
+
+func NewSRSender(
+	cfg *Config,
+	sendQueue chan<- *message.AddressedMessage,
+	recvQueue <-chan *message.AddressedMessage,
+	inputChan <-chan []byte,
+	remoteAddr netip.AddrPort,
+	isn uint32,
+	window uint32,
+	rtt *RTTEstimator,
+	cc CongestionController,
+	stats *connCounters,
+	logger *slog.Logger,
+	tracer *trace.ConnTracer,
+) *SRSender {
+	return &SRSender{
+		cfg:         cfg,
+		remoteAddr:  remoteAddr,
+		sendQueue:   sendQueue,
+		recvQueue:   recvQueue,
+		inputChan:   inputChan,
+		window:      window,
+		baseSeqNo:   isn,
+		nextSeqNo:   isn,
+		buf:         make([][]byte, window),
+		acked:       make([]bool, window),
+		sentAt:      make([]time.Time, window),
+		resent:      make([]bool, window),
+		rtt:         rtt,
+		cc:          cc,
+		rwnd:        window,
+		prober:      newWindowProber(cfg),
+		drain:       newDrainer(),
+		stats:       stats,
+		logger:      logger,
+		tracer:      tracer,
+		timers:      make([]clock.Timer, window),
+		timeoutChan: make(chan uint32, window),
+		term:        util.NewTerminator(),
+	}
+}
+
+func (s *SRSender) Start() {
+	defer s.term.Done()
+	defer s.stopTimers()
+	defer s.prober.timer.Stop()
+	for {
+		// only accept new payloads while the effective window has room
+		var inputChan <-chan []byte
+		if s.inFlight() < min(s.cc.Window(), s.window, s.rwnd) {
+			inputChan = s.inputChan
+		}
+		s.prober.update(s.rwnd, s.inFlight(), s.rtt.RTO())
+		s.drain.update(s.inFlight() == 0 && len(s.inputChan) == 0)
+		s.stats.window(s.inFlight(), min(s.cc.Window(), s.window, s.rwnd))
+
+		select {
+		case <-s.term.Quit():
+			return
+		case msg := <-s.recvQueue:
+			s.stats.acksReceived.Add(1)
+			// honour the receive window advertised by the receiver, even in acks outside of the window
+			s.rwnd = uint32(msg.Window)
+			if !s.inWindow(msg.SeqNo) {
+				s.stats.dupAcks.Add(1)
+				continue
+			}
+			// mark messages as acked, all up to the sequence no. for a cumulative ack and only that one for a selective ack
+			seqNo := msg.SeqNo
+			if !msg.Selective {
+				seqNo = s.baseSeqNo
+			}
+			var acked uint32
+			for ; seqNo != msg.SeqNo; seqNo = (seqNo + 1) % s.cfg.MaxSeqNo {
+				if s.ack(seqNo) {
+					acked++
+				}
+			}
+			if !s.ack(msg.SeqNo) {
+				if acked == 0 {
+					s.stats.dupAcks.Add(1)
+					continue
+				}
+			} else if acked++; !s.resent[s.slot(msg.SeqNo)] {
+				// sample round trip time unless the message was retransmitted (Karn's rule)
+				s.rtt.Sample(clock.Since(s.cfg.Clock, s.sentAt[s.slot(msg.SeqNo)]))
+			}
+			// grow congestion window unless recovering from a loss
+			if !s.inRecovery {
+				s.cc.OnAck(acked, s.inFlight(), s.window)
+			}
+			// shift window past all acked messages
+			baseSeqNo := s.baseSeqNo
+			for s.baseSeqNo != s.nextSeqNo && s.acked[s.baseSlot] {
+				s.acked[s.baseSlot] = false
+				if s.baseSeqNo == s.recoverSeqNo {
+					s.inRecovery = false
+				}
+				s.baseSeqNo = (s.baseSeqNo + 1) % s.cfg.MaxSeqNo
+				s.baseSlot = (s.baseSlot + 1) % s.window
+			}
+			if s.baseSeqNo != baseSeqNo {
+				s.laterAcks = 0
+				s.tracer.Emit(trace.Event{Kind: trace.KindWindow, Seq: s.baseSeqNo, InFlight: s.inFlight(), Window: min(s.cc.Window(), s.window, s.rwnd)})
+			} else {
+				// later messages arrived while the oldest did not, so it or its ack is likely lost,
+				// which is also assumed once all later messages are acked if there are too few for the threshold
+				s.laterAcks++
+				threshold := min(s.cfg.DupAckThreshold, int(s.inFlight())-1)
+				if s.cfg.DupAckThreshold > 0 && s.laterAcks >= threshold && !s.resent[s.baseSlot] {
+					s.fastRetransmit()
+				}
+			}
+		case payload := <-inputChan:
+			// store payload in buffer
+			idx := s.slot(s.nextSeqNo)
+			s.buf[idx] = payload
+			s.sentAt[idx] = s.cfg.Clock.Now()
+			s.resent[idx] = false
+			// send data and start its timer
+			s.send(s.nextSeqNo, "")
+			// increment next sequence no.
+			s.nextSeqNo = (s.nextSeqNo + 1) % s.cfg.MaxSeqNo
+		case seqNo := <-s.timeoutChan:
+			// resend only the expired message
+			if idx := s.slot(seqNo); s.inWindow(seqNo) && !s.acked[idx] {
+				s.stats.timeouts.Add(1)
+				s.logger.Debug("retransmission timeout", "seq", seqNo, "rto", s.rtt.RTO())
+				s.tracer.Emit(trace.Event{Kind: trace.KindTimerExpire, Seq: seqNo, RTO: s.rtt.RTO()})
+				// only the timeout of the oldest unacked message is a timeout event, as the later messages of a lost window
+				// expire with it, so that the timeout is doubled and the congestion window shrunk once per loss
+				if seqNo == s.baseSeqNo {
+					// slow start from the shrunk window instead of waiting for the recovery to end
+					s.cc.OnTimeout(s.inFlight())
+					s.inRecovery = false
+					s.rtt.Backoff()
+				}
+				s.resent[idx] = true
+				s.send(seqNo, "timeout")
+			}
+		case <-s.drain.Requested():
+			s.drain.start()
+		case <-s.prober.Channel():
+			s.prober.probe(s.sendQueue, s.remoteAddr, s.baseSeqNo)
+		}
+	}
+}
+
+func (s *SRSender) Stop() {
+	s.term.Terminate()
+}
+
+// Drain asks the sender to send the payloads left in its input channel,
+// which must no longer be written to, returning a channel that is closed once all of them are acked
+func (s *SRSender) Drain() <-chan struct{} {
+	return s.drain.request()
+}
+
+// ack marks the message with seqNo as acked and stops its timer, returning false if it already was
+func (s *SRSender) ack(seqNo uint32) bool {
+	idx := s.slot(seqNo)
+	if s.acked[idx] {
+		return false
+	}
+	s.acked[idx] = true
+	s.timers[idx].Stop()
+	s.tracer.Emit(trace.Event{Kind: trace.KindTimerStop, Seq: seqNo})
+	return true
+}
+
+// fastRetransmit resends the oldest unacked message without waiting for its timeout
+func (s *SRSender) fastRetransmit() {
+	s.logger.Debug("fast retransmit", "seq", s.baseSeqNo, "in_flight", s.inFlight())
+	if !s.inRecovery {
+		s.cc.OnLoss(s.inFlight())
+		s.inRecovery = true
+		s.recoverSeqNo = (s.nextSeqNo - 1 + s.cfg.MaxSeqNo) % s.cfg.MaxSeqNo
+	}
+	s.resent[s.baseSlot] = true
+	s.send(s.baseSeqNo, "dup_ack")
+}
+
+// send sends the buffered message with seqNo and (re)starts its retransmission timer,
+// tracing the reason if it is resent
+func (s *SRSender) send(seqNo uint32, reason string) {
+	idx := s.slot(seqNo)
+	msg := message.NewDataMessage(s.remoteAddr, seqNo, s.buf[idx])
+	s.sendQueue <- msg
+	s.stats.sent(s.buf[idx], s.resent[idx])
+	if s.resent[idx] {
+		s.tracer.Emit(trace.Event{Kind: trace.KindRetransmit, Seq: seqNo, Count: 1, InFlight: s.inFlight(), Reason: reason})
+	} else {
+		s.tracer.Emit(trace.Event{Kind: trace.KindSend, Seq: seqNo, InFlight: s.inFlight() + 1})
+	}
+	s.tracer.Emit(trace.Event{Kind: trace.KindTimerStart, Seq: seqNo, RTO: s.rtt.RTO()})
+	if s.timers[idx] != nil {
+		s.timers[idx].Stop()
+	}
+	s.timers[idx] = s.cfg.Clock.AfterFunc(s.rtt.RTO(), func() {
+		select {
+		case <-s.term.Quit():
+		case s.timeoutChan <- seqNo:
+		}
+	})
+}
+
+// slot obtains the buffer slot of seqNo within the window,
+// counted from the slot of baseSeqNo so that it is correct for any sequence no. space
+func (s *SRSender) slot(seqNo uint32) uint32 {
+	return (s.baseSlot + (seqNo-s.baseSeqNo+s.cfg.MaxSeqNo)%s.cfg.MaxSeqNo) % s.window
+}
+
+// inFlight obtains the number of messages between the window base and the next sequence no.
+func (s *SRSender) inFlight() uint32 {
+	return (s.nextSeqNo - s.baseSeqNo + s.cfg.MaxSeqNo) % s.cfg.MaxSeqNo
+}
+
+// inWindow reports whether seqNo has been sent but not yet slid out of the window
+func (s *SRSender) inWindow(seqNo uint32) bool {
+	offset := (seqNo - s.baseSeqNo + s.cfg.MaxSeqNo) % s.cfg.MaxSeqNo
+	return offset < s.inFlight()
+}
+
+// stopTimers stops all pending retransmission timers
+func (s *SRSender) stopTimers() {
+	for _, t := range s.timers {
+		if t != nil {
+			t.Stop()
+		}
+	}
+}
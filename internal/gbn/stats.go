@@ -0,0 +1,112 @@
+package gbn
+
+import (
+	"net/netip"
+	"sync/atomic"
+	"time"
+)
+
+// connCounters counts the events of a connection, updated by its sender and receiver
+type connCounters struct {
+	// sender
+	packetsSent     atomic.Uint64
+	bytesSent       atomic.Uint64
+	retransmissions atomic.Uint64
+	timeouts        atomic.Uint64
+	dupAcks         atomic.Uint64
+	acksReceived    atomic.Uint64
+	inFlight        atomic.Uint32
+	sendWindow      atomic.Uint32
+	// receiver
+	packetsReceived atomic.Uint64
+	bytesReceived   atomic.Uint64
+	outOfOrderDrops atomic.Uint64
+	acksSent        atomic.Uint64
+}
+
+// sent records a data message with payload, which is retransmitted if resent is set
+func (c *connCounters) sent(payload []byte, resent bool) {
+	c.packetsSent.Add(1)
+	c.bytesSent.Add(uint64(len(payload)))
+	if resent {
+		c.retransmissions.Add(1)
+	}
+}
+
+// window records the occupancy of the send window
+func (c *connCounters) window(inFlight, window uint32) {
+	c.inFlight.Store(inFlight)
+	c.sendWindow.Store(window)
+}
+
+// ConnStats is a snapshot of the counters and gauges of a connection
+type ConnStats struct {
+	Peer  netip.AddrPort // address of the peer
+	State string         // state of the connection, such as ESTABLISHED
+	// sender
+	PacketsSent      uint64        // data messages sent, including retransmissions
+	BytesSent        uint64        // payload bytes sent, including retransmissions
+	Retransmissions  uint64        // data messages sent again after a timeout or duplicate acks
+	Timeouts         uint64        // retransmission timeouts
+	DupAcks          uint64        // acks that acknowledged nothing new
+	AcksReceived     uint64        // acks received
+	InFlight         uint32        // data messages sent but not yet acked
+	SendWindow       uint32        // data messages that may be in flight, the minimum of cwnd, rwnd and the window
+	CongestionWindow uint32        // congestion window in messages
+	SSThresh         uint32        // slow start threshold in messages
+	SRTT             time.Duration // smoothed round trip time
+	RTTVar           time.Duration // round trip time variation
+	RTO              time.Duration // retransmission timeout
+	// receiver
+	PacketsReceived uint64 // data messages received, including duplicates and out of order ones
+	BytesReceived   uint64 // payload bytes accepted for delivery
+	OutOfOrderDrops uint64 // data messages dropped because they were duplicates, out of order or not buffered
+	AcksSent        uint64 // acks sent
+}
+
+// TransportStats is a snapshot of the counters and gauges of a transport and its connections
+type TransportStats struct {
+	Metrics
+	PacketsSent     uint64      // datagrams written to the socket
+	BytesSent       uint64      // bytes written to the socket
+	PacketsReceived uint64      // datagrams read from the socket
+	BytesReceived   uint64      // bytes read from the socket
+	EncodeErrors    uint64      // messages that could not be encoded
+	DecodeErrors    uint64      // datagrams that could not be decoded, including unauthentic ones
+	SendErrors      uint64      // messages dropped because the socket failed to send them
+	RecvErrors      uint64      // reads from the socket that failed, such as with an ICMP error
+	SendQueue       int         // messages waiting to be written to the socket
+	SendQueueCap    int         // capacity of the send queue
+	RecvQueue       int         // messages waiting to be demultiplexed
+	RecvQueueCap    int         // capacity of the receive queue
+	Conns           []ConnStats // every connection in any state
+}
+
+// stats obtains a snapshot of the counters and gauges of the connection
+func (ci *connInfo) stats() ConnStats {
+	ci.mu.Lock()
+	state := ci.state
+	ci.mu.Unlock()
+	c := &ci.counters
+	return ConnStats{
+		Peer:             ci.addr,
+		State:            state.String(),
+		PacketsSent:      c.packetsSent.Load(),
+		BytesSent:        c.bytesSent.Load(),
+		Retransmissions:  c.retransmissions.Load(),
+		Timeouts:         c.timeouts.Load(),
+		DupAcks:          c.dupAcks.Load(),
+		AcksReceived:     c.acksReceived.Load(),
+		InFlight:         c.inFlight.Load(),
+		SendWindow:       c.sendWindow.Load(),
+		CongestionWindow: ci.cc.Window(),
+		SSThresh:         ci.cc.SSThresh(),
+		SRTT:             ci.rtt.SRTT(),
+		RTTVar:           ci.rtt.RTTVar(),
+		RTO:              ci.rtt.RTO(),
+		PacketsReceived:  c.packetsReceived.Load(),
+		BytesReceived:    c.bytesReceived.Load(),
+		OutOfOrderDrops:  c.outOfOrderDrops.Load(),
+		AcksSent:         c.acksSent.Load(),
+	}
+}
@@ -1,17 +1,20 @@
 package gbn
 
-import "time"
+import (
+	"rdt/internal/clock"
+	"time"
+)
 
-// TimeoutTimer represents a timer that will timeout after a fixed duration
+// TimeoutTimer represents a timer that will timeout after a configurable duration
 type TimeoutTimer struct {
-	timer *time.Timer
+	timer clock.Timer
 	d     time.Duration
 }
 
-// NewTimeoutTimer creates a stopped TimeoutTimer with duration d
-func NewTimeoutTimer(d time.Duration) *TimeoutTimer {
+// NewTimeoutTimer creates a stopped TimeoutTimer with duration d on clk
+func NewTimeoutTimer(clk clock.Clock, d time.Duration) *TimeoutTimer {
 	t := &TimeoutTimer{
-		timer: time.NewTimer(0),
+		timer: clk.NewTimer(0),
 		d:     d,
 	}
 	t.Stop()
@@ -30,12 +33,17 @@ func (t *TimeoutTimer) Stop() {
 		return
 	}
 	select {
-	case <-t.timer.C:
+	case <-t.timer.C():
 	default:
 	}
 }
 
+// SetDuration changes the duration used by subsequent calls to Start
+func (t *TimeoutTimer) SetDuration(d time.Duration) {
+	t.d = d
+}
+
 // Channel obtains a channel where the current time will be sent upon timeout
 func (t *TimeoutTimer) Channel() <-chan time.Time {
-	return t.timer.C
+	return t.timer.C()
 }
@@ -1,79 +1,360 @@
 package gbn
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net"
 	"net/netip"
-	"rdt/internal/config"
+	"os"
+	"rdt/internal/clock"
 	"rdt/internal/message"
+	"rdt/internal/pcapng"
+	"rdt/internal/seal"
+	"rdt/internal/trace"
 	"rdt/internal/udp"
+	"rdt/internal/util"
+	"sync"
+)
+
+var (
+	ErrTransportClosed = errors.New("transport closed")
+	ErrUnknownPeer     = errors.New("no established connection with peer")
 )
 
 type Transport struct {
+	cfg      *Config
 	sender   *udp.Sender
 	receiver *udp.Receiver
 	mux      *Multiplexer
-	conn     *net.UDPConn
+	conn     udp.PacketConn
+	sealer   *seal.Codec // seals datagrams if a pre-shared key is configured
+	capture  *os.File    // file that datagrams are recorded in if packet capture is configured
+	trace    *os.File    // file that protocol decisions are recorded in if tracing is configured
+	// failure reporting
+	mu        sync.Mutex
+	err       error         // reason the transport stopped, guarded by mu
+	done      chan struct{} // closed once the transport stopped or failed
+	abortOnce sync.Once
+	term      *util.Terminator // watches the UDP goroutines for failures
 }
 
-func NewClientTransport() *Transport {
-	return newTransport(0, false)
+// NewClientTransport creates a transport bound to an ephemeral port
+// that only connects to peers it dials
+func NewClientTransport(cfg Config) (*Transport, error) {
+	conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(netip.IPv6Unspecified(), 0)))
+	if err != nil {
+		return nil, err
+	}
+	return NewTransport(conn, false, cfg)
 }
 
-func NewServerTransport() *Transport {
-	return newTransport(config.PortNumber, true)
+// NewServerTransport creates a transport bound to laddr
+// that accepts connections from any peer
+func NewServerTransport(laddr netip.AddrPort, cfg Config) (*Transport, error) {
+	conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(laddr))
+	if err != nil {
+		return nil, err
+	}
+	return NewTransport(conn, true, cfg)
 }
 
-func newTransport(port uint16, isServer bool) *Transport {
-	conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(
-		netip.AddrPortFrom(netip.IPv6Unspecified(), port),
-	))
+// NewTransport creates a transport that exchanges datagrams via conn,
+// such as an endpoint of a simulated network, and accepts connections from peers if isServer is set.
+// All timing uses cfg.Clock, which must also drive the deadlines of conn.
+// The transport takes ownership of conn, closing it if the transport cannot be created.
+func NewTransport(conn udp.PacketConn, isServer bool, cfg Config) (*Transport, error) {
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	cfg.Logger = cfg.Logger.With("local", conn.LocalAddr().String())
+	if err := cfg.Validate(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	codec, err := message.ParseCodec(cfg.Codec)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	newCC, err := ParseCongestionControl(cfg.CongestionControl)
 	if err != nil {
-		log.Fatalln("Failed to bind to port:", err)
+		_ = conn.Close()
+		return nil, err
+	}
+	var sealer *seal.Codec
+	if cfg.PreSharedKey != "" {
+		key, err := seal.ParseKey(cfg.PreSharedKey)
+		if err == nil {
+			sealer, err = seal.NewCodec(codec, key, cfg.Clock)
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		codec = sealer
 	}
-	inputChan := make(chan rune, config.InputChanBufferSize)
-	outputChan := make(chan rune, config.OutputChanBufferSize)
-	sendChan := make(chan *message.AddressedMessage, config.SendChanBufferSize)
-	recvChan := make(chan *message.AddressedMessage, config.RecvChanBufferSize)
+	var captureFile *os.File
+	var capture *pcapng.Writer
+	if cfg.CaptureFile != "" {
+		captureFile, capture, err = createCapture(cfg.CaptureFile, conn.LocalAddr().(*net.UDPAddr).AddrPort())
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	var traceFile *os.File
+	var tracer *trace.Tracer
+	if cfg.TraceFile != "" {
+		traceFile, err = os.Create(cfg.TraceFile)
+		if err != nil {
+			if captureFile != nil {
+				_ = captureFile.Close()
+			}
+			_ = conn.Close()
+			return nil, fmt.Errorf("trace: %w", err)
+		}
+		tracer = trace.NewTracer(traceFile, cfg.Clock)
+	}
+	sendChan := make(chan *message.AddressedMessage, cfg.SendChanBufferSize)
+	recvChan := make(chan *message.AddressedMessage, cfg.RecvChanBufferSize)
+	mux := NewMultiplexer(&cfg, sendChan, recvChan, isServer, newCC, tracer)
 	return &Transport{
-		sender:   udp.NewSender(conn, sendChan),
-		receiver: udp.NewReceiver(conn, recvChan),
-		mux:      NewMultiplexer(sendChan, recvChan, inputChan, outputChan, isServer),
+		cfg:      &cfg,
+		sender:   udp.NewSender(conn, sendChan, codec, cfg.Clock, cfg.UDPWriteTimeout, cfg.Logger, mux.connAttrs, capture),
+		receiver: udp.NewReceiver(conn, recvChan, codec, cfg.Clock, cfg.UDPReadTimeout, cfg.UDPRecvBufferSize, cfg.Logger, mux.connAttrs, capture),
+		mux:      mux,
 		conn:     conn,
+		sealer:   sealer,
+		capture:  captureFile,
+		trace:    traceFile,
+		done:     make(chan struct{}),
+		term:     util.NewTerminator(),
+	}, nil
+}
+
+// createCapture creates the pcapng file name that records the datagrams of the socket bound to local
+func createCapture(name string, local netip.AddrPort) (*os.File, *pcapng.Writer, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("packet capture: %w", err)
+	}
+	w, err := pcapng.NewWriter(f, local, "rdt "+local.String())
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("packet capture: %w", err)
+	}
+	return f, w, nil
+}
+
+// Dial opens a connection to addr, waiting until the handshake completes
+func (t *Transport) Dial(ctx context.Context, addr netip.AddrPort) (*Conn, error) {
+	select {
+	case <-t.done:
+		return nil, t.Err()
+	default:
+	}
+	ci, err := t.mux.connect(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{ci: ci}, nil
+}
+
+// Done obtains a channel that is closed once the transport is stopped
+// or fails because of an I/O error on its socket
+func (t *Transport) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err obtains the reason the transport is done:
+// ErrTransportClosed after Close or Abort, the I/O error that made it fail, or nil while it runs
+func (t *Transport) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Conn obtains the established connection with the peer at addr
+func (t *Transport) Conn(addr netip.AddrPort) (*Conn, error) {
+	ci, found := t.mux.loadConnInfo(addr)
+	if !found || !ci.isEstablished() {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownPeer, addr)
+	}
+	return &Conn{ci: ci}, nil
+}
+
+// Conns obtains all established connections
+func (t *Transport) Conns() []*Conn {
+	var conns []*Conn
+	for _, ci := range t.mux.loadAllConnInfos() {
+		if ci.isEstablished() {
+			conns = append(conns, &Conn{ci: ci})
+		}
+	}
+	return conns
+}
+
+// SendTo sends payload to the single peer at addr
+func (t *Transport) SendTo(ctx context.Context, addr netip.AddrPort, payload []byte) error {
+	conn, err := t.Conn(addr)
+	if err != nil {
+		return err
+	}
+	return conn.Send(ctx, payload)
+}
+
+// Broadcast sends payload to every established connection,
+// returning the errors of connections it could not be sent to
+func (t *Transport) Broadcast(ctx context.Context, payload []byte) error {
+	var errs []error
+	for _, conn := range t.Conns() {
+		if err := conn.Send(ctx, payload); err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", conn.RemoteAddr(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Metrics obtains the counters of the transport and its connections
+func (t *Transport) Metrics() Metrics {
+	metrics := t.mux.Metrics()
+	if t.sealer != nil {
+		metrics.RejectedAuth = t.sealer.Rejected()
+		metrics.RejectedReplay = t.sealer.Replayed()
 	}
+	return metrics
 }
 
-// RegisterAddress registers addr with the multiplexer
-func (t *Transport) RegisterAddress(addr netip.AddrPort) {
-	t.mux.registerAddress(addr)
+// Stats obtains a snapshot of the counters and gauges of the transport and all of its connections
+func (t *Transport) Stats() TransportStats {
+	sent := t.sender.Stats()
+	received := t.receiver.Stats()
+	stats := TransportStats{
+		Metrics:         t.Metrics(),
+		PacketsSent:     sent.Packets,
+		BytesSent:       sent.Bytes,
+		PacketsReceived: received.Packets,
+		BytesReceived:   received.Bytes,
+		EncodeErrors:    sent.Dropped,
+		DecodeErrors:    received.Dropped,
+		SendErrors:      sent.Errors,
+		RecvErrors:      received.Errors,
+		SendQueue:       len(t.mux.sendChan),
+		SendQueueCap:    cap(t.mux.sendChan),
+		RecvQueue:       len(t.mux.recvChan),
+		RecvQueueCap:    cap(t.mux.recvChan),
+	}
+	for _, ci := range t.mux.loadAllConnInfos() {
+		stats.Conns = append(stats.Conns, ci.stats())
+	}
+	return stats
 }
 
-// InputChan obtains a sendable channel for input characters to screen
-func (t *Transport) InputChan() chan<- rune {
-	return t.mux.inputChan
+// AcceptChan obtains a receivable channel for connections opened by peers
+func (t *Transport) AcceptChan() <-chan *Conn {
+	return t.mux.acceptChan
 }
 
-// OutputChan obtains a receivable channel for output characters to screen
-func (t *Transport) OutputChan() <-chan rune {
-	return t.mux.outputChan
+// LocalAddr obtains the address the transport is bound to
+func (t *Transport) LocalAddr() netip.AddrPort {
+	return t.conn.LocalAddr().(*net.UDPAddr).AddrPort()
 }
 
 func (t *Transport) Start() {
+	t.cfg.Logger.Info("transport started", "mode", t.cfg.Mode, "server", t.mux.autoRegister, "sealed", t.sealer != nil)
 	go t.sender.Start()
 	go t.receiver.Start()
 	go t.mux.Start()
+	go t.watch()
+}
+
+// watch fails the transport and all of its connections once a UDP goroutine fails
+func (t *Transport) watch() {
+	defer t.term.Done()
+	var err error
+	select {
+	case <-t.term.Quit():
+		return
+	case <-t.sender.Failed():
+		err = t.sender.Err()
+	case <-t.receiver.Failed():
+		err = t.receiver.Err()
+	}
+	if t.setDone(err) {
+		t.cfg.Logger.Error("transport failed", "err", err)
+		t.mux.fail(err)
+	}
+}
+
+// setDone records why the transport is done, returning false if it already was
+func (t *Transport) setDone(err error) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return false
+	}
+	t.err = err
+	close(t.done)
+	return true
+}
+
+// Close refuses new connections and closes all connections gracefully,
+// waiting until their payloads are acked and their peers acknowledged the teardown,
+// before releasing the socket. Connections still open once ctx expires are aborted.
+// It returns the errors of connections that did not close gracefully.
+func (t *Transport) Close(ctx context.Context) error {
+	t.mux.refuse()
+	cis := t.mux.loadAllConnInfos()
+	errs := make([]error, len(cis))
+	var wg sync.WaitGroup
+	for i, ci := range cis {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := &Conn{ci: ci}
+			if err := conn.Close(ctx); err != nil {
+				errs[i] = fmt.Errorf("%v: %w", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+	wg.Wait()
+	t.Abort()
+	return errors.Join(errs...)
 }
 
-func (t *Transport) Stop() {
+// Abort stops the transport and all of its connections immediately without notifying peers,
+// discarding any unacked payloads.
+// It may be called more than once, including after the transport failed or was closed.
+func (t *Transport) Abort() {
+	t.abortOnce.Do(t.abort)
+}
+
+func (t *Transport) abort() {
+	t.term.Terminate()
+	t.setDone(ErrTransportClosed)
+	t.mux.Stop()
 	t.sender.Stop()
-	log.Println("udp.Sender stopped")
+	// closing conn unblocks a pending read instead of waiting for its deadline
+	_ = t.conn.Close()
 	t.receiver.Stop()
-	log.Println("udp.Receiver stopped")
-	t.mux.Stop()
-	log.Println("gbn.Multiplexer stopped")
+	if t.capture != nil {
+		// the UDP goroutines are stopped, so nothing is recorded anymore
+		if err := t.capture.Close(); err != nil {
+			t.cfg.Logger.Error("failed to close packet capture", "err", err)
+		}
+	}
+	if t.trace != nil {
+		// all connections are finished, so nothing is traced anymore
+		if err := errors.Join(t.mux.tracer.Err(), t.trace.Close()); err != nil {
+			t.cfg.Logger.Error("failed to close trace", "err", err)
+		}
+	}
+	t.cfg.Logger.Info("transport stopped")
 	close(t.mux.sendChan)
 	close(t.mux.recvChan)
-	close(t.mux.inputChan)
-	close(t.mux.outputChan)
-	_ = t.conn.Close()
 }
@@ -0,0 +1,218 @@
+package gbn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/netip"
+	"rdt/internal/clock"
+	"rdt/internal/simnet"
+	"sync"
+	"testing"
+	"time"
+)
+
+var serverAddr = netip.MustParseAddrPort("[::1]:9000")
+
+// newTestPair creates a client and a server transport with cfg connected over a simulated network with impairments netCfg,
+// which are stopped when the test ends
+func newTestPair(t *testing.T, cfg Config, netCfg simnet.Config) (*Transport, *Transport) {
+	t.Helper()
+	n, server := newTestServer(t, cfg, netCfg)
+	return newTestClient(t, n, cfg), server
+}
+
+// newTestServer creates a simulated network with impairments netCfg and a server transport with cfg listening on it at serverAddr,
+// which are stopped when the test ends
+func newTestServer(t *testing.T, cfg Config, netCfg simnet.Config) (*simnet.Network, *Transport) {
+	t.Helper()
+	if cfg.Logger == nil {
+		cfg.Logger = discardLogger()
+	}
+	netCfg.Clock = cfg.Clock
+	n := simnet.New(netCfg)
+	go n.Start()
+	t.Cleanup(n.Stop)
+
+	sconn, err := n.Listen(netip.AddrPortFrom(netip.IPv6Unspecified(), serverAddr.Port()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewTransport(sconn, true, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	t.Cleanup(server.Abort)
+	return n, server
+}
+
+// newTestClient creates a client transport with cfg on n, which is stopped when the test ends
+func newTestClient(t *testing.T, n *simnet.Network, cfg Config) *Transport {
+	t.Helper()
+	if cfg.Logger == nil {
+		cfg.Logger = discardLogger()
+	}
+	cconn, err := n.Listen(netip.AddrPort{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewTransport(cconn, false, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Start()
+	t.Cleanup(client.Abort)
+	return client
+}
+
+// runClock advances clk by 5ms about every millisecond until the test ends,
+// so that protocol timers driven by it fire quickly
+func runClock(t *testing.T, clk *clock.Fake) {
+	quit, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-quit:
+				return
+			case <-time.After(50 * time.Microsecond):
+				clk.Advance(5 * time.Millisecond)
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(quit)
+		<-done
+	})
+}
+
+// waitFor polls cond until it holds, failing the test with what after a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+	}
+}
+
+// discardLogger obtains a logger that drops all records
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// dialAccept connects client to server, returning both ends of the connection
+func dialAccept(t *testing.T, client, server *Transport) (*Conn, *Conn) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := client.Dial(ctx, serverAddr)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	select {
+	case s := <-server.AcceptChan():
+		return c, s
+	case <-ctx.Done():
+		t.Fatal("accept:", ctx.Err())
+		return nil, nil
+	}
+}
+
+// receive reads payloads from conn like rdt.Conn does, preferring data over closure,
+// until the connection is closed or timeout passes
+func receive(conn *Conn, timeout time.Duration) [][]byte {
+	var payloads [][]byte
+	deadline := time.After(timeout)
+	for {
+		select {
+		case p := <-conn.OutputChan():
+			payloads = append(payloads, p)
+			continue
+		default:
+		}
+		select {
+		case p := <-conn.OutputChan():
+			payloads = append(payloads, p)
+		case <-conn.Done():
+			// data may have arrived while waiting, and select picks among ready cases at random
+			select {
+			case p := <-conn.OutputChan():
+				payloads = append(payloads, p)
+			default:
+				return payloads
+			}
+		case <-deadline:
+			return payloads
+		}
+	}
+}
+
+func TestDialRefusedAfterAbort(t *testing.T) {
+	client, _ := newTestPair(t, DefaultConfig(), simnet.Config{Delay: time.Millisecond})
+	client.Abort()
+	// as by a dial that checked the transport just before it was aborted
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.mux.connect(ctx, serverAddr); !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("dial failed with %v, want %v", err, ErrTransportClosed)
+	}
+}
+
+// syncBuffer is a buffer that may be written and read concurrently
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
+func TestPacketTraceHasConnID(t *testing.T) {
+	var logs syncBuffer
+	cfg := DefaultConfig()
+	cfg.Logger = slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client, server := newTestPair(t, cfg, simnet.Config{Delay: time.Millisecond})
+	c, s := dialAccept(t, client, server)
+	if err := c.Send(context.Background(), []byte("x")); err != nil {
+		t.Fatal("send:", err)
+	}
+	if got := receive(s, 100*time.Millisecond); len(got) != 1 {
+		t.Fatalf("received %d payloads, want 1", len(got))
+	}
+
+	var traced int
+	for lines := bufio.NewScanner(bytes.NewReader(logs.Bytes())); lines.Scan(); {
+		var record struct {
+			Msg  string
+			Type string
+			Conn *uint64
+		}
+		if err := json.Unmarshal(lines.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		if (record.Msg == "send" || record.Msg == "recv") && (record.Type == "DATA" || record.Type == "ACK") {
+			traced++
+			if record.Conn == nil {
+				t.Fatalf("%s of %s traced without conn: %s", record.Msg, record.Type, lines.Bytes())
+			}
+		}
+	}
+	if traced < 4 {
+		t.Fatalf("traced %d DATA and ACK packets, want at least 4", traced)
+	}
+}
@@ -0,0 +1,62 @@
+// Package logging builds the structured logger of the binaries,
+// whose packet traces at debug level can be switched on and off while running.
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Flags holds the command line flags that configure logging
+type Flags struct {
+	level  *string
+	format *string
+}
+
+// RegisterFlags registers -log-level and -log-format on fs,
+// defaulting to the environment variables LOG_LEVEL and LOG_FORMAT
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	return &Flags{
+		level:  fs.String("log-level", envOr("LOG_LEVEL", "info"), "minimum level of log records (debug|info|warn|error), where debug traces every packet"),
+		format: fs.String("log-format", envOr("LOG_FORMAT", "text"), "format of log records (text|json)"),
+	}
+}
+
+// Logger creates a logger writing to w as configured by the parsed flags,
+// along with the level it logs at, which may be changed while running
+func (f *Flags) Logger(w io.Writer) (*slog.Logger, *slog.LevelVar, error) {
+	level := new(slog.LevelVar)
+	if err := level.UnmarshalText([]byte(*f.level)); err != nil {
+		return nil, nil, fmt.Errorf("log level: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(*f.format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), level, nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), level, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown log format %q", *f.format)
+	}
+}
+
+// toggleTrace switches level to debug, which traces every packet, or back to base
+func toggleTrace(logger *slog.Logger, level *slog.LevelVar, base slog.Level) {
+	if level.Level() == slog.LevelDebug {
+		level.Set(base)
+	} else {
+		level.Set(slog.LevelDebug)
+	}
+	logger.Info("packet tracing switched", "trace", level.Level() == slog.LevelDebug)
+}
+
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
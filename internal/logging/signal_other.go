@@ -0,0 +1,9 @@
+//go:build !unix
+
+package logging
+
+import "log/slog"
+
+// ToggleTraceOnSignal does nothing since SIGUSR1 does not exist on this platform,
+// so packet tracing can only be chosen with the log level at startup
+func ToggleTraceOnSignal(logger *slog.Logger, level *slog.LevelVar) {}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package logging
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ToggleTraceOnSignal switches packet tracing on and off whenever the process receives SIGUSR1
+func ToggleTraceOnSignal(logger *slog.Logger, level *slog.LevelVar) {
+	base := level.Level()
+	if base == slog.LevelDebug {
+		base = slog.LevelInfo
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	go func() {
+		for range sigs {
+			toggleTrace(logger, level, base)
+		}
+	}()
+}
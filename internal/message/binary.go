@@ -0,0 +1,136 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// Binary wire format (all fields big endian):
+//
+//	offset  size  field
+//	0       1     magic (high nibble) | version (low nibble)
+//	1       1     message type
+//	2       1     flags
+//	3       4     sequence no.
+//	7       2     window
+//	9       2     payload length
+//	11      4     CRC32 (IEEE) of header and payload, computed with this field zeroed
+//	15      n     payload
+//
+// SYN and SYNACK messages carry the offered sequence no. space as their 4 byte payload,
+// followed by the cookie in a SYN echoing one, and set FlagSelective to offer Selective Repeat.
+const (
+	Magic      = 0xA // identifies datagrams of this protocol
+	Version    = 3   // version of the binary wire format
+	HeaderSize = 15  // size in bytes of the binary header
+)
+
+// message flags
+const (
+	FlagSelective byte = 1 << 0 // ack covers only SeqNo, or SYN or SYNACK offers Selective Repeat
+)
+
+var (
+	ErrTruncated = errors.New("datagram shorter than its header")
+	ErrMagic     = errors.New("datagram has bad magic number")
+	ErrVersion   = errors.New("datagram has unsupported version")
+	ErrLength    = errors.New("datagram payload length mismatch")
+	ErrChecksum  = errors.New("datagram checksum mismatch")
+)
+
+func (message *Message) MarshalBinary() (data []byte, err error) {
+	var flags byte
+	var payload []byte
+	switch message.Type {
+	case TypeAck:
+	case TypeData:
+		payload = message.Payload
+		if len(payload) > MaxPayloadSize {
+			return nil, errors.New("message of type DATA has payload larger than MaxPayloadSize")
+		}
+	case TypeSyn, TypeSynAck:
+		payload = binary.BigEndian.AppendUint32(nil, message.MaxSeqNo)
+		if message.Type == TypeSyn && message.Cookie != 0 {
+			payload = binary.BigEndian.AppendUint32(payload, message.Cookie)
+		}
+	case TypeFin, TypeFinAck, TypeKeepalive, TypeKeepaliveAck, TypeCookie:
+	default:
+		return nil, fmt.Errorf("message type %d unknown", message.Type)
+	}
+
+	if message.Selective && (message.Type == TypeAck || message.Type == TypeSyn || message.Type == TypeSynAck) {
+		flags |= FlagSelective
+	}
+	data = make([]byte, HeaderSize+len(payload))
+	data[0] = Magic<<4 | Version
+	data[1] = byte(message.Type)
+	data[2] = flags
+	binary.BigEndian.PutUint32(data[3:7], message.SeqNo)
+	binary.BigEndian.PutUint16(data[7:9], message.Window)
+	binary.BigEndian.PutUint16(data[9:11], uint16(len(payload)))
+	copy(data[HeaderSize:], payload)
+	binary.BigEndian.PutUint32(data[11:15], crc32.ChecksumIEEE(data))
+	return
+}
+
+func (message *Message) UnmarshalBinary(data []byte) (err error) {
+	// validate header before trusting any field
+	if len(data) < HeaderSize {
+		return ErrTruncated
+	}
+	if data[0]>>4 != Magic {
+		return ErrMagic
+	}
+	if data[0]&0xF != Version {
+		return ErrVersion
+	}
+	length := int(binary.BigEndian.Uint16(data[9:11]))
+	if len(data) != HeaderSize+length {
+		return ErrLength
+	}
+	// verify checksum with checksum field zeroed
+	checksum := binary.BigEndian.Uint32(data[11:15])
+	h := crc32.NewIEEE()
+	_, _ = h.Write(data[:11])
+	_, _ = h.Write([]byte{0, 0, 0, 0})
+	_, _ = h.Write(data[HeaderSize:])
+	if h.Sum32() != checksum {
+		return ErrChecksum
+	}
+
+	payload := data[HeaderSize:]
+	msgType := Type(data[1])
+	message.MaxSeqNo = 0
+	message.Cookie = 0
+	switch msgType {
+	case TypeData:
+		if length > MaxPayloadSize {
+			return errors.New("message of type DATA has payload larger than MaxPayloadSize")
+		}
+		// copy payload since data may be reused by the caller
+		message.Payload = append([]byte(nil), payload...)
+	case TypeSyn, TypeSynAck:
+		if length != 4 && (length != 8 || msgType != TypeSyn) {
+			return fmt.Errorf("message of type %s has payload that is not an offer", msgType)
+		}
+		message.MaxSeqNo = binary.BigEndian.Uint32(payload)
+		if length == 8 {
+			message.Cookie = binary.BigEndian.Uint32(payload[4:])
+		}
+		message.Payload = nil
+	case TypeAck, TypeFin, TypeFinAck, TypeKeepalive, TypeKeepaliveAck, TypeCookie:
+		if length != 0 {
+			return fmt.Errorf("message of type %s must not have payload", msgType)
+		}
+		message.Payload = nil
+	default:
+		return fmt.Errorf("message type %d unknown", data[1])
+	}
+	message.Type = msgType
+	message.Selective = (msgType == TypeAck || msgType == TypeSyn || msgType == TypeSynAck) && data[2]&FlagSelective != 0
+	message.SeqNo = binary.BigEndian.Uint32(data[3:7])
+	message.Window = binary.BigEndian.Uint16(data[7:9])
+	return
+}
@@ -0,0 +1,59 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Codec encodes messages into datagrams and decodes datagrams into messages
+type Codec interface {
+	Marshal(message *Message) ([]byte, error)
+	Unmarshal(data []byte, message *Message) error
+}
+
+// BinaryCodec encodes messages in the compact, checksummed binary wire format
+var BinaryCodec Codec = binaryCodec{}
+
+// TextCodec encodes messages in the human-readable text format, intended for debugging
+var TextCodec Codec = textCodec{}
+
+// ParseCodec parses the name of a codec
+func ParseCodec(name string) (Codec, error) {
+	switch name {
+	case "binary":
+		return BinaryCodec, nil
+	case "text":
+		return TextCodec, nil
+	default:
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+}
+
+// IsCorrupt reports whether err indicates a datagram damaged in transit
+// rather than a well-formed datagram with invalid contents
+func IsCorrupt(err error) bool {
+	return errors.Is(err, ErrTruncated) ||
+		errors.Is(err, ErrMagic) ||
+		errors.Is(err, ErrLength) ||
+		errors.Is(err, ErrChecksum)
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Marshal(message *Message) ([]byte, error) {
+	return message.MarshalBinary()
+}
+
+func (binaryCodec) Unmarshal(data []byte, message *Message) error {
+	return message.UnmarshalBinary(data)
+}
+
+type textCodec struct{}
+
+func (textCodec) Marshal(message *Message) ([]byte, error) {
+	return message.MarshalText()
+}
+
+func (textCodec) Unmarshal(data []byte, message *Message) error {
+	return message.UnmarshalText(data)
+}
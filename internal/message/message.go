@@ -3,83 +3,238 @@ package message
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/netip"
 	"strconv"
 	"strings"
 )
 
+// MaxPayloadSize is the largest payload a data message may carry,
+// chosen so that a datagram fits within a typical path MTU
+const MaxPayloadSize = 1200
+
+// MaxTextSize is the size in bytes of the largest message in the text format,
+// a DATA message whose payload is escaped as \xNN byte by byte
+const MaxTextSize = len("DATA 4294967295 \"\"\n") + 4*MaxPayloadSize
+
 // AddressedMessage represents a message sent to/received from Addr
 type AddressedMessage struct {
 	Message
 	Addr netip.AddrPort
 }
 
-// Message represents either data item with a character or an acknowledgement.
+// Type identifies the purpose of a message
+type Type byte
+
+const (
+	TypeData         Type = 1 // data item with a payload
+	TypeAck          Type = 2 // acknowledgement of data
+	TypeSyn          Type = 3 // connection request carrying the initial sequence no.
+	TypeSynAck       Type = 4 // connection response carrying the initial sequence no.
+	TypeFin          Type = 5 // connection close request
+	TypeFinAck       Type = 6 // connection close response
+	TypeKeepalive    Type = 7 // liveness probe sent on an idle connection
+	TypeKeepaliveAck Type = 8 // liveness probe response
+	TypeCookie       Type = 9 // challenge to repeat a connection request with the cookie in SeqNo
+)
+
+var typeNames = map[Type]string{
+	TypeData:         "DATA",
+	TypeAck:          "ACK",
+	TypeSyn:          "SYN",
+	TypeSynAck:       "SYNACK",
+	TypeFin:          "FIN",
+	TypeFinAck:       "FINACK",
+	TypeKeepalive:    "KEEPALIVE",
+	TypeKeepaliveAck: "KEEPALIVEACK",
+	TypeCookie:       "COOKIE",
+}
+
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("Type(%d)", byte(t))
+}
+
+// IsControl reports whether messages of type t manage the connection
+// rather than transfer data
+func (t Type) IsControl() bool {
+	return t == TypeSyn || t == TypeSynAck || t == TypeFin || t == TypeFinAck ||
+		t == TypeKeepalive || t == TypeKeepaliveAck || t == TypeCookie
+}
+
+// Message represents either data item with a payload, an acknowledgement or a connection control message.
 type Message struct {
-	IsAck bool   // flag that indicates the message is an acknowledgement
-	SeqNo uint32 // sequence no. of message
-	Char  rune   // character sent in data message
+	Type      Type   // purpose of message
+	Selective bool   // flag that indicates an acknowledgement covers only SeqNo instead of all messages up to SeqNo, or that a SYN or SYNACK message offers Selective Repeat
+	SeqNo     uint32 // sequence no. of message
+	Window    uint16 // window size offered in SYN and SYNACK messages, or free receive buffer space in ACK messages
+	MaxSeqNo  uint32 // size of the sequence no. space offered in SYN and SYNACK messages
+	Cookie    uint32 // cookie echoed in a SYN message after a challenge, or 0 if there is none
+	Payload   []byte // bytes sent in data message
+}
+
+func NewDataMessage(addr netip.AddrPort, seqNo uint32, payload []byte) *AddressedMessage {
+	return &AddressedMessage{
+		Message: Message{
+			Type:    TypeData,
+			SeqNo:   seqNo,
+			Payload: payload,
+		},
+		Addr: addr,
+	}
 }
 
-func NewDataMessage(addr netip.AddrPort, seqNo uint32, char rune) *AddressedMessage {
+func NewAckMessage(addr netip.AddrPort, seqNo uint32, window uint16) *AddressedMessage {
 	return &AddressedMessage{
 		Message: Message{
-			IsAck: false,
-			SeqNo: seqNo,
-			Char:  char,
+			Type:   TypeAck,
+			SeqNo:  seqNo,
+			Window: window,
 		},
 		Addr: addr,
 	}
 }
 
-func NewAckMessage(addr netip.AddrPort, seqNo uint32) *AddressedMessage {
+// NewSelectiveAckMessage creates an acknowledgement for the single message with seqNo
+func NewSelectiveAckMessage(addr netip.AddrPort, seqNo uint32, window uint16) *AddressedMessage {
 	return &AddressedMessage{
 		Message: Message{
-			IsAck: true,
-			SeqNo: seqNo,
+			Type:      TypeAck,
+			Selective: true,
+			SeqNo:     seqNo,
+			Window:    window,
 		},
 		Addr: addr,
 	}
 }
 
+// NewControlMessage creates a connection control message of type t
+func NewControlMessage(addr netip.AddrPort, t Type, seqNo uint32, window uint16) *AddressedMessage {
+	return &AddressedMessage{
+		Message: Message{
+			Type:   t,
+			SeqNo:  seqNo,
+			Window: window,
+		},
+		Addr: addr,
+	}
+}
+
+// LogAttrs obtains the attributes that identify message in log records
+func (message *AddressedMessage) LogAttrs() []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("peer", message.Addr.String()),
+		slog.String("type", message.Type.String()),
+		slog.Uint64("seq", uint64(message.SeqNo)),
+	}
+	switch message.Type {
+	case TypeData:
+		attrs = append(attrs, slog.Int("len", len(message.Payload)))
+	case TypeAck:
+		attrs = append(attrs, slog.Uint64("window", uint64(message.Window)))
+	case TypeSyn, TypeSynAck:
+		attrs = append(attrs, slog.Uint64("window", uint64(message.Window)), slog.Uint64("max_seq", uint64(message.MaxSeqNo)), slog.Bool("selective", message.Selective))
+	}
+	return attrs
+}
+
 func (message *Message) MarshalText() (text []byte, err error) {
-	if message.IsAck {
-		// format ack message
-		text = []byte(fmt.Sprintln("ACK", message.SeqNo))
-	} else {
+	switch message.Type {
+	case TypeAck:
+		if message.Selective {
+			// format selective ack message
+			text = []byte(fmt.Sprintln("SACK", message.SeqNo, message.Window))
+		} else {
+			// format ack message
+			text = []byte(fmt.Sprintln("ACK", message.SeqNo, message.Window))
+		}
+	case TypeData:
 		// format data message
-		text = []byte(fmt.Sprintln("DATA", message.SeqNo, string(message.Char)))
+		if len(message.Payload) > MaxPayloadSize {
+			err = errors.New("message of type DATA has payload larger than MaxPayloadSize")
+			return
+		}
+		text = []byte(fmt.Sprintln("DATA", message.SeqNo, strconv.Quote(string(message.Payload))))
+	case TypeSyn, TypeSynAck:
+		// format handshake message with the offered protocol
+		protocol := "GBN"
+		if message.Selective {
+			protocol = "SR"
+		}
+		if message.Type == TypeSyn && message.Cookie != 0 {
+			text = []byte(fmt.Sprintln(message.Type, message.SeqNo, message.Window, message.MaxSeqNo, protocol, message.Cookie))
+		} else {
+			text = []byte(fmt.Sprintln(message.Type, message.SeqNo, message.Window, message.MaxSeqNo, protocol))
+		}
+	case TypeFin, TypeFinAck, TypeKeepalive, TypeKeepaliveAck, TypeCookie:
+		// format teardown, keepalive or challenge message
+		text = []byte(fmt.Sprintln(message.Type, message.SeqNo))
+	default:
+		err = errors.New("message type unknown")
 	}
 	return
 }
 
+// String formats message in the text format without the trailing newline
+func (message *Message) String() string {
+	text, _ := message.MarshalText()
+	return strings.TrimSpace(string(text))
+}
+
 func (message *Message) UnmarshalText(text []byte) (err error) {
-	// split into fields
-	fields := strings.Fields(string(text))
-	if len(fields) == 0 {
+	// split into fields, keeping the quoted payload intact
+	fields := strings.SplitN(strings.TrimSpace(string(text)), " ", 3)
+	if len(fields) == 0 || fields[0] == "" {
 		err = errors.New("message has no fields")
 		return
 	}
 
+	// determine type and number of fields
+	var numFields int
+	message.Selective = false
+	message.Window = 0
+	message.MaxSeqNo = 0
+	message.Cookie = 0
+	message.Payload = nil
 	switch fields[0] {
-	case "ACK":
-		// ensure SeqNo exists
-		if len(fields) != 2 {
-			err = errors.New("message of type ACK has wrong number of fields")
-			return
-		}
-		message.IsAck = true
+	case "ACK", "SACK":
+		message.Type = TypeAck
+		message.Selective = fields[0] == "SACK"
+		numFields = 3
 	case "DATA":
-		// ensure SeqNo and Char exists
-		if len(fields) != 3 {
-			err = errors.New("message of type DATA has wrong number of fields")
-			return
+		message.Type = TypeData
+		numFields = 3
+	case "SYN", "SYNACK":
+		message.Type = TypeSyn
+		if fields[0] == "SYNACK" {
+			message.Type = TypeSynAck
 		}
-		message.IsAck = false
+		numFields = 3
+	case "FIN", "FINACK":
+		message.Type = TypeFin
+		if fields[0] == "FINACK" {
+			message.Type = TypeFinAck
+		}
+		numFields = 2
+	case "KEEPALIVE", "KEEPALIVEACK":
+		message.Type = TypeKeepalive
+		if fields[0] == "KEEPALIVEACK" {
+			message.Type = TypeKeepaliveAck
+		}
+		numFields = 2
+	case "COOKIE":
+		message.Type = TypeCookie
+		numFields = 2
 	default:
 		err = errors.New("message type unknown")
 		return
 	}
+	if len(fields) != numFields {
+		err = fmt.Errorf("message of type %s has wrong number of fields", fields[0])
+		return
+	}
 
 	// parse SeqNo
 	s, err := strconv.ParseUint(fields[1], 10, 32)
@@ -88,14 +243,62 @@ func (message *Message) UnmarshalText(text []byte) (err error) {
 	}
 	message.SeqNo = uint32(s)
 
-	if !message.IsAck {
-		// ensure payload is only a single Char
-		if len(fields[2]) != 1 {
-			err = errors.New("message of type DATA must have payload of single Char")
+	switch message.Type {
+	case TypeData:
+		// parse quoted payload
+		payload, uerr := strconv.Unquote(fields[2])
+		if uerr != nil {
+			err = fmt.Errorf("message of type DATA has malformed payload: %w", uerr)
+			return
+		}
+		if len(payload) > MaxPayloadSize {
+			err = errors.New("message of type DATA has payload larger than MaxPayloadSize")
+			return
+		}
+		message.Payload = []byte(payload)
+	case TypeAck:
+		// parse free receive buffer space
+		w, werr := strconv.ParseUint(fields[2], 10, 16)
+		if werr != nil {
+			err = werr
+			return
+		}
+		message.Window = uint16(w)
+	case TypeSyn, TypeSynAck:
+		// parse offered window, sequence no. space and protocol, followed by the optional cookie of a SYN
+		offer := strings.Split(fields[2], " ")
+		if len(offer) != 3 && (len(offer) != 4 || message.Type != TypeSyn) {
+			err = fmt.Errorf("message of type %s has wrong number of fields", fields[0])
 			return
 		}
-		// parse payload into Char
-		message.Char = rune(fields[2][0])
+		w, werr := strconv.ParseUint(offer[0], 10, 16)
+		if werr != nil {
+			err = werr
+			return
+		}
+		message.Window = uint16(w)
+		m, merr := strconv.ParseUint(offer[1], 10, 32)
+		if merr != nil {
+			err = merr
+			return
+		}
+		message.MaxSeqNo = uint32(m)
+		switch offer[2] {
+		case "GBN":
+		case "SR":
+			message.Selective = true
+		default:
+			err = fmt.Errorf("message of type %s has unknown protocol %q", fields[0], offer[2])
+			return
+		}
+		if len(offer) == 4 {
+			c, cerr := strconv.ParseUint(offer[3], 10, 32)
+			if cerr != nil {
+				err = cerr
+				return
+			}
+			message.Cookie = uint32(c)
+		}
 	}
 
 	return
@@ -0,0 +1,132 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+// messages holds a message of every type with all the fields its type carries
+var messages = []struct {
+	name    string
+	message Message
+}{
+	{"ack", Message{Type: TypeAck, SeqNo: 7, Window: 12}},
+	{"selective ack", Message{Type: TypeAck, SeqNo: 4294967295, Window: 65535, Selective: true}},
+	{"data", Message{Type: TypeData, SeqNo: 3, Payload: []byte("hello \"world\"\n\x00\xff")}},
+	{"full data", Message{Type: TypeData, SeqNo: 4, Payload: bytes.Repeat([]byte{0xff}, MaxPayloadSize)}},
+	{"syn", Message{Type: TypeSyn, SeqNo: 100, Window: 8, MaxSeqNo: 1 << 16}},
+	{"syn with cookie", Message{Type: TypeSyn, SeqNo: 100, Window: 8, MaxSeqNo: 64, Selective: true, Cookie: 0xdeadbeef}},
+	{"synack", Message{Type: TypeSynAck, SeqNo: 200, Window: 16, MaxSeqNo: 64, Selective: true}},
+	{"fin", Message{Type: TypeFin, SeqNo: 9}},
+	{"finack", Message{Type: TypeFinAck, SeqNo: 9}},
+	{"keepalive", Message{Type: TypeKeepalive, SeqNo: 1}},
+	{"keepaliveack", Message{Type: TypeKeepaliveAck, SeqNo: 1}},
+	{"cookie", Message{Type: TypeCookie, SeqNo: 0xcafe}},
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range []struct {
+		name    string
+		codec   Codec
+		maxSize int
+	}{
+		{"binary", BinaryCodec, HeaderSize + MaxPayloadSize},
+		{"text", TextCodec, MaxTextSize},
+	} {
+		for _, tc := range messages {
+			t.Run(codec.name+"/"+tc.name, func(t *testing.T) {
+				data, err := codec.codec.Marshal(&tc.message)
+				if err != nil {
+					t.Fatal("marshal:", err)
+				}
+				if len(data) > codec.maxSize {
+					t.Fatalf("datagram of %d bytes exceeds %d", len(data), codec.maxSize)
+				}
+				// decode into a message holding stale fields to check that all of them are overwritten
+				got := Message{Payload: []byte("stale"), MaxSeqNo: 1, Cookie: 1, Selective: true, Window: 1}
+				if err := codec.codec.Unmarshal(data, &got); err != nil {
+					t.Fatal("unmarshal:", err)
+				}
+				if !reflect.DeepEqual(got, tc.message) {
+					t.Fatalf("decoded %+v, want %+v", got, tc.message)
+				}
+			})
+		}
+	}
+}
+
+func TestUnmarshalBinaryRejectsDamage(t *testing.T) {
+	valid, err := (&Message{Type: TypeData, SeqNo: 5, Payload: []byte("payload")}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	damage := func(f func(data []byte) []byte) []byte {
+		return f(append([]byte(nil), valid...))
+	}
+
+	for _, tc := range []struct {
+		name    string
+		data    []byte
+		want    error
+		corrupt bool
+	}{
+		{"empty", nil, ErrTruncated, true},
+		{"truncated header", valid[:HeaderSize-1], ErrTruncated, true},
+		{"wrong magic", damage(func(d []byte) []byte { d[0] = (Magic+1)<<4 | Version; return d }), ErrMagic, true},
+		{"wrong version", damage(func(d []byte) []byte { d[0] = Magic<<4 | (Version + 1); return d }), ErrVersion, false},
+		{"truncated payload", valid[:len(valid)-1], ErrLength, true},
+		{"trailing bytes", append(append([]byte(nil), valid...), 0), ErrLength, true},
+		{"length too large", damage(func(d []byte) []byte { binary.BigEndian.PutUint16(d[9:11], 8); return d }), ErrLength, true},
+		{"length too small", damage(func(d []byte) []byte { binary.BigEndian.PutUint16(d[9:11], 6); return d }), ErrLength, true},
+		{"flipped payload bit", damage(func(d []byte) []byte { d[HeaderSize] ^= 1; return d }), ErrChecksum, true},
+		{"flipped seq no. bit", damage(func(d []byte) []byte { d[3] ^= 0x80; return d }), ErrChecksum, true},
+		{"flipped checksum bit", damage(func(d []byte) []byte { d[11] ^= 1; return d }), ErrChecksum, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var msg Message
+			err := msg.UnmarshalBinary(tc.data)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("failed with %v, want %v", err, tc.want)
+			}
+			if IsCorrupt(err) != tc.corrupt {
+				t.Fatalf("IsCorrupt(%v) = %t, want %t", err, !tc.corrupt, tc.corrupt)
+			}
+		})
+	}
+}
+
+func TestUnmarshalBinaryRejectsInvalidPayload(t *testing.T) {
+	// valid checksums over payloads that do not fit the message type
+	for _, tc := range []struct {
+		name    string
+		msgType Type
+		payload []byte
+	}{
+		{"ack with payload", TypeAck, []byte{1}},
+		{"syn without offer", TypeSyn, nil},
+		{"synack with cookie", TypeSynAck, make([]byte, 8)},
+		{"oversized data", TypeData, make([]byte, MaxPayloadSize+1)},
+		{"unknown type", Type(0xff), nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := make([]byte, HeaderSize+len(tc.payload))
+			data[0] = Magic<<4 | Version
+			data[1] = byte(tc.msgType)
+			binary.BigEndian.PutUint16(data[9:11], uint16(len(tc.payload)))
+			copy(data[HeaderSize:], tc.payload)
+			binary.BigEndian.PutUint32(data[11:15], crc32.ChecksumIEEE(data))
+			var msg Message
+			err := msg.UnmarshalBinary(data)
+			if err == nil {
+				t.Fatal("accepted invalid payload")
+			}
+			if IsCorrupt(err) {
+				t.Fatalf("%v reported as corrupt", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// Package metrics serves the stats of a transport over HTTP,
+// in the Prometheus text format and as expvar JSON.
+package metrics
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"rdt/internal/gbn"
+	"strconv"
+	"sync"
+)
+
+var publishOnce sync.Once
+
+// Handler serves the stats obtained from stats in the Prometheus text format at /metrics
+// and as JSON, together with all other expvar variables, at /debug/vars.
+// Since expvar variables are global, only the stats of the first handler are published as expvar "rdt".
+func Handler(stats func() gbn.TransportStats) http.Handler {
+	publishOnce.Do(func() {
+		expvar.Publish("rdt", expvar.Func(func() any {
+			return stats()
+		}))
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = WritePrometheus(w, stats())
+	})
+	return mux
+}
+
+// metric describes how a value of a snapshot of type T is exposed
+type metric[T any] struct {
+	name  string
+	help  string
+	kind  string // counter or gauge
+	value func(s *T) float64
+}
+
+var transportMetrics = []metric[gbn.TransportStats]{
+	{"rdt_packets_sent_total", "Datagrams written to the socket.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.PacketsSent) }},
+	{"rdt_bytes_sent_total", "Bytes written to the socket.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.BytesSent) }},
+	{"rdt_packets_received_total", "Datagrams read from the socket.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.PacketsReceived) }},
+	{"rdt_bytes_received_total", "Bytes read from the socket.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.BytesReceived) }},
+	{"rdt_encode_errors_total", "Messages that could not be encoded.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.EncodeErrors) }},
+	{"rdt_decode_errors_total", "Datagrams that could not be decoded.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.DecodeErrors) }},
+	{"rdt_send_errors_total", "Messages dropped because the socket failed to send them.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.SendErrors) }},
+	{"rdt_recv_errors_total", "Reads from the socket that failed.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.RecvErrors) }},
+	{"rdt_send_queue_length", "Messages waiting to be written to the socket.", "gauge", func(s *gbn.TransportStats) float64 { return float64(s.SendQueue) }},
+	{"rdt_send_queue_capacity", "Capacity of the send queue.", "gauge", func(s *gbn.TransportStats) float64 { return float64(s.SendQueueCap) }},
+	{"rdt_recv_queue_length", "Messages waiting to be demultiplexed.", "gauge", func(s *gbn.TransportStats) float64 { return float64(s.RecvQueue) }},
+	{"rdt_recv_queue_capacity", "Capacity of the receive queue.", "gauge", func(s *gbn.TransportStats) float64 { return float64(s.RecvQueueCap) }},
+	{"rdt_peers_active", "Peers with a connection in any state.", "gauge", func(s *gbn.TransportStats) float64 { return float64(s.ActivePeers) }},
+	{"rdt_peers_evicted_total", "Connections evicted after their idle timeout.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.EvictedPeers) }},
+	{"rdt_syn_challenged_total", "Connection requests answered with a cookie challenge.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.Challenged) }},
+	{"rdt_syn_rejected_rate_limit_total", "Connection requests dropped by the per-IP rate limit.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.RejectedRateLimit) }},
+	{"rdt_syn_rejected_cookie_total", "Connection requests with a forged or expired cookie.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.RejectedCookie) }},
+	{"rdt_syn_rejected_peer_limit_total", "Connection requests dropped at the peer cap.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.RejectedPeerLimit) }},
+	{"rdt_syn_rejected_incompatible_total", "Connection requests offering another protocol or sequence no. space.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.RejectedIncompatible) }},
+	{"rdt_datagrams_rejected_auth_total", "Datagrams that failed authentication.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.RejectedAuth) }},
+	{"rdt_datagrams_rejected_replay_total", "Authentic datagrams dropped as replays.", "counter", func(s *gbn.TransportStats) float64 { return float64(s.RejectedReplay) }},
+}
+
+var connMetrics = []metric[gbn.ConnStats]{
+	{"rdt_conn_packets_sent_total", "Data messages sent, including retransmissions.", "counter", func(s *gbn.ConnStats) float64 { return float64(s.PacketsSent) }},
+	{"rdt_conn_bytes_sent_total", "Payload bytes sent, including retransmissions.", "counter", func(s *gbn.ConnStats) float64 { return float64(s.BytesSent) }},
+	{"rdt_conn_retransmissions_total", "Data messages sent again.", "counter", func(s *gbn.ConnStats) float64 { return float64(s.Retransmissions) }},
+	{"rdt_conn_timeouts_total", "Retransmission timeouts.", "counter", func(s *gbn.ConnStats) float64 { return float64(s.Timeouts) }},
+	{"rdt_conn_dup_acks_total", "Acks that acknowledged nothing new.", "counter", func(s *gbn.ConnStats) float64 { return float64(s.DupAcks) }},
+	{"rdt_conn_acks_received_total", "Acks received.", "counter", func(s *gbn.ConnStats) float64 { return float64(s.AcksReceived) }},
+	{"rdt_conn_in_flight", "Data messages sent but not yet acked.", "gauge", func(s *gbn.ConnStats) float64 { return float64(s.InFlight) }},
+	{"rdt_conn_send_window", "Data messages that may be in flight.", "gauge", func(s *gbn.ConnStats) float64 { return float64(s.SendWindow) }},
+	{"rdt_conn_cwnd", "Congestion window in messages.", "gauge", func(s *gbn.ConnStats) float64 { return float64(s.CongestionWindow) }},
+	{"rdt_conn_srtt_seconds", "Smoothed round trip time.", "gauge", func(s *gbn.ConnStats) float64 { return s.SRTT.Seconds() }},
+	{"rdt_conn_rttvar_seconds", "Round trip time variation.", "gauge", func(s *gbn.ConnStats) float64 { return s.RTTVar.Seconds() }},
+	{"rdt_conn_rto_seconds", "Retransmission timeout.", "gauge", func(s *gbn.ConnStats) float64 { return s.RTO.Seconds() }},
+	{"rdt_conn_packets_received_total", "Data messages received.", "counter", func(s *gbn.ConnStats) float64 { return float64(s.PacketsReceived) }},
+	{"rdt_conn_bytes_received_total", "Payload bytes accepted for delivery.", "counter", func(s *gbn.ConnStats) float64 { return float64(s.BytesReceived) }},
+	{"rdt_conn_out_of_order_drops_total", "Data messages dropped as duplicates, out of order or not buffered.", "counter", func(s *gbn.ConnStats) float64 { return float64(s.OutOfOrderDrops) }},
+	{"rdt_conn_acks_sent_total", "Acks sent.", "counter", func(s *gbn.ConnStats) float64 { return float64(s.AcksSent) }},
+}
+
+// WritePrometheus writes stats to w in the Prometheus text exposition format,
+// labelling the metrics of each connection with the address of its peer
+func WritePrometheus(w io.Writer, stats gbn.TransportStats) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range transportMetrics {
+		writeHeader(bw, m.name, m.help, m.kind)
+		fmt.Fprintf(bw, "%s %s\n", m.name, formatValue(m.value(&stats)))
+	}
+	writeHeader(bw, "rdt_conn_info", "State of each connection.", "gauge")
+	for i := range stats.Conns {
+		c := &stats.Conns[i]
+		fmt.Fprintf(bw, "rdt_conn_info{peer=%s,state=%s} 1\n", strconv.Quote(c.Peer.String()), strconv.Quote(c.State))
+	}
+	for _, m := range connMetrics {
+		writeHeader(bw, m.name, m.help, m.kind)
+		for i := range stats.Conns {
+			c := &stats.Conns[i]
+			fmt.Fprintf(bw, "%s{peer=%s} %s\n", m.name, strconv.Quote(c.Peer.String()), formatValue(m.value(c)))
+		}
+	}
+	return bw.Flush()
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
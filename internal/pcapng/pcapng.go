@@ -0,0 +1,207 @@
+// Package pcapng records datagrams into a capture file in the pcapng format,
+// wrapping each in synthetic IP and UDP headers so that tools such as Wireshark can open it.
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Direction of a recorded datagram as seen from the local socket
+type Direction uint32
+
+const (
+	Inbound  Direction = 1 // datagram received from the peer
+	Outbound Direction = 2 // datagram sent to the peer
+)
+
+// block types and options of the pcapng format
+const (
+	blockSectionHeader   = 0x0A0D0D0A
+	blockInterface       = 0x00000001
+	blockEnhancedPacket  = 0x00000006
+	byteOrderMagic       = 0x1A2B3C4D
+	linkTypeRaw          = 101 // packets begin with an IPv4 or IPv6 header
+	optEndOfOpt          = 0
+	optInterfaceName     = 2
+	optEPBFlags          = 2
+	protocolUDP          = 17
+	ipv4HeaderSize       = 20
+	ipv6HeaderSize       = 40
+	udpHeaderSize        = 8
+	enhancedPacketHeader = 28 // block type, length, interface id, timestamps, captured and original length
+)
+
+// Writer records datagrams exchanged by a single local socket.
+// It is safe for concurrent use by the sender and receiver of the socket.
+type Writer struct {
+	mu    sync.Mutex
+	w     io.Writer
+	local netip.AddrPort
+	err   error // first write error, after which nothing more is recorded
+}
+
+// NewWriter creates a writer that records datagrams of the socket bound to local into w,
+// starting with the section header and the description of the interface named name
+func NewWriter(w io.Writer, local netip.AddrPort, name string) (*Writer, error) {
+	cw := &Writer{w: w, local: local}
+	if err := cw.writeHeader(name); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *Writer) writeHeader(name string) error {
+	// section header without options, of unspecified length
+	shb := make([]byte, 0, 28)
+	shb = binary.LittleEndian.AppendUint32(shb, blockSectionHeader)
+	shb = binary.LittleEndian.AppendUint32(shb, 28)
+	shb = binary.LittleEndian.AppendUint32(shb, byteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1) // major version
+	shb = binary.LittleEndian.AppendUint16(shb, 0) // minor version
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+	shb = binary.LittleEndian.AppendUint32(shb, 28)
+	// interface description with microsecond timestamps, the default resolution
+	var opts []byte
+	opts = appendOption(opts, optInterfaceName, []byte(name))
+	opts = appendOption(opts, optEndOfOpt, nil)
+	length := uint32(20 + len(opts))
+	idb := make([]byte, 0, length)
+	idb = binary.LittleEndian.AppendUint32(idb, blockInterface)
+	idb = binary.LittleEndian.AppendUint32(idb, length)
+	idb = binary.LittleEndian.AppendUint16(idb, linkTypeRaw)
+	idb = binary.LittleEndian.AppendUint16(idb, 0) // reserved
+	idb = binary.LittleEndian.AppendUint32(idb, 0) // no snapshot length limit
+	idb = append(idb, opts...)
+	idb = binary.LittleEndian.AppendUint32(idb, length)
+	_, err := cw.w.Write(append(shb, idb...))
+	return err
+}
+
+// WriteDatagram records datagram exchanged with peer at time t in direction dir.
+// After a write fails, nothing more is recorded and the error is returned only once.
+func (cw *Writer) WriteDatagram(t time.Time, dir Direction, peer netip.AddrPort, datagram []byte) error {
+	src, dst := cw.local, peer
+	if dir == Inbound {
+		src, dst = peer, cw.local
+	}
+	packet := appendPacket(nil, src, dst, datagram)
+
+	var opts []byte
+	opts = appendOption(opts, optEPBFlags, binary.LittleEndian.AppendUint32(nil, uint32(dir)))
+	opts = appendOption(opts, optEndOfOpt, nil)
+	padded := pad(len(packet))
+	length := uint32(enhancedPacketHeader + padded + len(opts) + 4)
+	ts := uint64(t.UnixMicro())
+	block := make([]byte, 0, length)
+	block = binary.LittleEndian.AppendUint32(block, blockEnhancedPacket)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	block = binary.LittleEndian.AppendUint32(block, 0) // interface id
+	block = binary.LittleEndian.AppendUint32(block, uint32(ts>>32))
+	block = binary.LittleEndian.AppendUint32(block, uint32(ts))
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(packet)))
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(packet)))
+	block = append(block, packet...)
+	block = append(block, make([]byte, padded-len(packet))...)
+	block = append(block, opts...)
+	block = binary.LittleEndian.AppendUint32(block, length)
+
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.err != nil {
+		return nil
+	}
+	_, cw.err = cw.w.Write(block)
+	return cw.err
+}
+
+// Err obtains the error that stopped recording, if any
+func (cw *Writer) Err() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.err
+}
+
+// appendPacket appends datagram sent from src to dst, wrapped in IP and UDP headers.
+// IPv4 headers are used if both addresses are IPv4 or IPv4-mapped, treating an unspecified address as either.
+func appendPacket(b []byte, src, dst netip.AddrPort, datagram []byte) []byte {
+	srcIP, dstIP := src.Addr().Unmap(), dst.Addr().Unmap()
+	if srcIP.Is4() && dstIP.IsUnspecified() {
+		dstIP = netip.IPv4Unspecified()
+	} else if dstIP.Is4() && srcIP.IsUnspecified() {
+		srcIP = netip.IPv4Unspecified()
+	}
+	udpLength := udpHeaderSize + len(datagram)
+	// pseudo header covered by the UDP checksum
+	var pseudo []byte
+	if srcIP.Is4() && dstIP.Is4() {
+		header := make([]byte, ipv4HeaderSize)
+		header[0] = 4<<4 | ipv4HeaderSize/4
+		binary.BigEndian.PutUint16(header[2:4], uint16(ipv4HeaderSize+udpLength))
+		header[8] = 64 // time to live
+		header[9] = protocolUDP
+		copy(header[12:16], srcIP.AsSlice())
+		copy(header[16:20], dstIP.AsSlice())
+		binary.BigEndian.PutUint16(header[10:12], ^sum(0, header))
+		b = append(b, header...)
+		pseudo = append(pseudo, header[12:20]...)
+		pseudo = append(pseudo, 0, protocolUDP)
+		pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(udpLength))
+	} else {
+		srcIP16, dstIP16 := src.Addr().As16(), dst.Addr().As16()
+		header := make([]byte, ipv6HeaderSize)
+		header[0] = 6 << 4
+		binary.BigEndian.PutUint16(header[4:6], uint16(udpLength))
+		header[6] = protocolUDP
+		header[7] = 64 // hop limit
+		copy(header[8:24], srcIP16[:])
+		copy(header[24:40], dstIP16[:])
+		b = append(b, header...)
+		pseudo = append(pseudo, header[8:40]...)
+		pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(udpLength))
+		pseudo = append(pseudo, 0, 0, 0, protocolUDP)
+	}
+	udp := make([]byte, udpHeaderSize, udpLength)
+	binary.BigEndian.PutUint16(udp[0:2], src.Port())
+	binary.BigEndian.PutUint16(udp[2:4], dst.Port())
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpLength))
+	udp = append(udp, datagram...)
+	checksum := ^sum(sum(0, pseudo), udp)
+	if checksum == 0 {
+		// zero means no checksum, so it is sent as all ones
+		checksum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(udp[6:8], checksum)
+	return append(b, udp...)
+}
+
+// sum adds data to the one's complement sum acc used by IP and UDP checksums
+func sum(acc uint16, data []byte) uint16 {
+	s := uint32(acc)
+	for i := 0; i+1 < len(data); i += 2 {
+		s += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		s += uint32(data[len(data)-1]) << 8
+	}
+	for s > 0xFFFF {
+		s = s>>16 + s&0xFFFF
+	}
+	return uint16(s)
+}
+
+// appendOption appends an option with value, padded to 32 bits
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return append(b, make([]byte, pad(len(value))-len(value))...)
+}
+
+// pad rounds n up to a multiple of 32 bits
+func pad(n int) int {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,167 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// block is a pcapng block split into its type and body, the bytes between the length fields
+type block struct {
+	typ  uint32
+	body []byte
+}
+
+// readBlocks splits data into blocks, checking that both length fields of each block agree
+func readBlocks(t *testing.T, data []byte) []block {
+	t.Helper()
+	var blocks []block
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("%d trailing bytes are too short for a block", len(data))
+		}
+		typ := binary.LittleEndian.Uint32(data[0:4])
+		length := binary.LittleEndian.Uint32(data[4:8])
+		if length%4 != 0 || int(length) > len(data) {
+			t.Fatalf("block %#x has length %d with %d bytes left", typ, length, len(data))
+		}
+		if trailer := binary.LittleEndian.Uint32(data[length-4 : length]); trailer != length {
+			t.Fatalf("block %#x has length %d at its start and %d at its end", typ, length, trailer)
+		}
+		blocks = append(blocks, block{typ, data[8 : length-4]})
+		data = data[length:]
+	}
+	return blocks
+}
+
+// readOptions decodes options up to the end of options option
+func readOptions(t *testing.T, data []byte) map[uint16][]byte {
+	t.Helper()
+	opts := make(map[uint16][]byte)
+	for {
+		if len(data) < 4 {
+			t.Fatal("options not terminated")
+		}
+		code := binary.LittleEndian.Uint16(data[0:2])
+		length := int(binary.LittleEndian.Uint16(data[2:4]))
+		if code == optEndOfOpt {
+			return opts
+		}
+		opts[code] = data[4 : 4+length]
+		data = data[4+pad(length):]
+	}
+}
+
+func TestWriterLayout(t *testing.T) {
+	local := netip.MustParseAddrPort("[::1]:8080")
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, local, "rdt0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := []struct {
+		t        time.Time
+		dir      Direction
+		peer     netip.AddrPort
+		datagram []byte
+	}{
+		// a packet that needs padding and one that does not
+		{time.UnixMicro(1_700_000_000_123_456), Outbound, netip.MustParseAddrPort("[2001:db8::1]:4000"), []byte("odd")},
+		{time.UnixMicro(1_700_000_001_000_000), Inbound, netip.MustParseAddrPort("[2001:db8::1]:4000"), []byte("even")},
+	}
+	for _, p := range packets {
+		if err := w.WriteDatagram(p.t, p.dir, p.peer, p.datagram); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blocks := readBlocks(t, buf.Bytes())
+	if len(blocks) != 2+len(packets) {
+		t.Fatalf("wrote %d blocks, want %d", len(blocks), 2+len(packets))
+	}
+	shb := blocks[0]
+	if shb.typ != blockSectionHeader || binary.LittleEndian.Uint32(shb.body[0:4]) != byteOrderMagic {
+		t.Fatalf("first block %#x is not a little endian section header", shb.typ)
+	}
+	if major, minor := binary.LittleEndian.Uint16(shb.body[4:6]), binary.LittleEndian.Uint16(shb.body[6:8]); major != 1 || minor != 0 {
+		t.Fatalf("section header has version %d.%d, want 1.0", major, minor)
+	}
+	idb := blocks[1]
+	if idb.typ != blockInterface || binary.LittleEndian.Uint16(idb.body[0:2]) != linkTypeRaw {
+		t.Fatalf("second block %#x is not an interface of raw IP packets", idb.typ)
+	}
+	if name := readOptions(t, idb.body[8:])[optInterfaceName]; string(name) != "rdt0" {
+		t.Fatalf("interface named %q, want %q", name, "rdt0")
+	}
+
+	for i, p := range packets {
+		epb := blocks[2+i]
+		if epb.typ != blockEnhancedPacket {
+			t.Fatalf("block %d has type %#x, want an enhanced packet", 2+i, epb.typ)
+		}
+		body := epb.body
+		if id := binary.LittleEndian.Uint32(body[0:4]); id != 0 {
+			t.Fatalf("packet %d on interface %d, want 0", i, id)
+		}
+		ts := uint64(binary.LittleEndian.Uint32(body[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(body[8:12]))
+		if ts != uint64(p.t.UnixMicro()) {
+			t.Fatalf("packet %d at %d µs, want %d", i, ts, p.t.UnixMicro())
+		}
+		captured, original := binary.LittleEndian.Uint32(body[12:16]), binary.LittleEndian.Uint32(body[16:20])
+		if captured != original || captured != ipv6HeaderSize+udpHeaderSize+uint32(len(p.datagram)) {
+			t.Fatalf("packet %d captured %d of %d bytes, want all %d", i, captured, original, ipv6HeaderSize+udpHeaderSize+len(p.datagram))
+		}
+		packet := body[20 : 20+captured]
+		if flags := readOptions(t, body[20+pad(int(captured)):])[optEPBFlags]; binary.LittleEndian.Uint32(flags) != uint32(p.dir) {
+			t.Fatalf("packet %d has flags %x, want direction %d", i, flags, p.dir)
+		}
+
+		// the addresses and ports follow the direction
+		if version := packet[0] >> 4; version != 6 {
+			t.Fatalf("packet %d has IP version %d, want 6", i, version)
+		}
+		src, dst := local, p.peer
+		if p.dir == Inbound {
+			src, dst = p.peer, local
+		}
+		srcIP, _ := netip.AddrFromSlice(packet[8:24])
+		dstIP, _ := netip.AddrFromSlice(packet[24:40])
+		udp := packet[ipv6HeaderSize:]
+		gotSrc := netip.AddrPortFrom(srcIP, binary.BigEndian.Uint16(udp[0:2]))
+		gotDst := netip.AddrPortFrom(dstIP, binary.BigEndian.Uint16(udp[2:4]))
+		if gotSrc != src || gotDst != dst {
+			t.Fatalf("packet %d sent from %v to %v, want %v to %v", i, gotSrc, gotDst, src, dst)
+		}
+		if !bytes.Equal(udp[udpHeaderSize:], p.datagram) {
+			t.Fatalf("packet %d carries %q, want %q", i, udp[udpHeaderSize:], p.datagram)
+		}
+		// a valid checksum sums to all ones over the pseudo header and the UDP header and data
+		pseudo := append(append([]byte(nil), packet[8:40]...), 0, 0, 0, 0, 0, 0, 0, protocolUDP)
+		binary.BigEndian.PutUint32(pseudo[32:36], uint32(len(udp)))
+		if s := sum(sum(0, pseudo), udp); s != 0xFFFF {
+			t.Fatalf("packet %d has bad UDP checksum, sum %#x", i, s)
+		}
+	}
+}
+
+func TestAppendPacketIPv4(t *testing.T) {
+	src := netip.MustParseAddrPort("[::ffff:192.0.2.1]:4000")
+	dst := netip.MustParseAddrPort("[::]:8080")
+	packet := appendPacket(nil, src, dst, []byte("data"))
+	if len(packet) != ipv4HeaderSize+udpHeaderSize+4 {
+		t.Fatalf("packet of %d bytes, want %d", len(packet), ipv4HeaderSize+udpHeaderSize+4)
+	}
+	if version := packet[0] >> 4; version != 4 {
+		t.Fatalf("IP version %d for IPv4-mapped addresses, want 4", version)
+	}
+	if s := sum(0, packet[:ipv4HeaderSize]); s != 0xFFFF {
+		t.Fatalf("bad IPv4 header checksum, sum %#x", s)
+	}
+	srcIP, _ := netip.AddrFromSlice(packet[12:16])
+	dstIP, _ := netip.AddrFromSlice(packet[16:20])
+	if srcIP != src.Addr().Unmap() || dstIP != netip.IPv4Unspecified() {
+		t.Fatalf("packet from %v to %v, want %v to %v", srcIP, dstIP, src.Addr().Unmap(), netip.IPv4Unspecified())
+	}
+}
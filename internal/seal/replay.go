@@ -0,0 +1,47 @@
+package seal
+
+// replayWindowSize is the number of packet nos. below the highest one received
+// that are still accepted if they arrive late
+const replayWindowSize = 1024
+
+// replayWindow tracks the packet nos. received from a sender with a sliding bitmap
+type replayWindow struct {
+	highest uint64 // highest packet no. received
+	started bool   // flag that indicates a packet was received
+	bits    [replayWindowSize / 64]uint64
+}
+
+// accept reports whether packetNo was not received before and is not too old, recording it if so
+func (w *replayWindow) accept(packetNo uint64) bool {
+	if !w.started || packetNo > w.highest {
+		shift := packetNo - w.highest
+		if !w.started || shift >= replayWindowSize {
+			w.bits = [replayWindowSize / 64]uint64{}
+		} else {
+			for i := w.highest + 1; i <= packetNo; i++ {
+				w.clear(i)
+			}
+		}
+		w.started = true
+		w.highest = packetNo
+		w.set(packetNo)
+		return true
+	}
+	if w.highest-packetNo >= replayWindowSize || w.isSet(packetNo) {
+		return false
+	}
+	w.set(packetNo)
+	return true
+}
+
+func (w *replayWindow) set(packetNo uint64) {
+	w.bits[packetNo/64%uint64(len(w.bits))] |= 1 << (packetNo % 64)
+}
+
+func (w *replayWindow) clear(packetNo uint64) {
+	w.bits[packetNo/64%uint64(len(w.bits))] &^= 1 << (packetNo % 64)
+}
+
+func (w *replayWindow) isSet(packetNo uint64) bool {
+	return w.bits[packetNo/64%uint64(len(w.bits))]&(1<<(packetNo%64)) != 0
+}
@@ -0,0 +1,233 @@
+// Package seal authenticates and encrypts datagrams with a pre-shared key.
+//
+// Sealed datagram format:
+//
+//	offset  size  field
+//	0       16    sender id, chosen randomly by each sender
+//	16      8     packet no., counting the datagrams of the sender from 0
+//	24      8     time of sealing in nanoseconds since the Unix epoch
+//	32      n     encoded message sealed with AES-256-GCM, including the 16 byte tag
+//
+// Each sender seals with its own key, derived from the pre-shared key and its sender id,
+// and uses its packet no. as the nonce, so nonces are never reused under a key.
+// The header is authenticated as additional data.
+// Receivers reject packet nos. they already accepted from a sender,
+// and datagrams sealed more than MaxAge before or after the time they are opened.
+//
+// Receivers only remember senders in memory, so after a receiver restarts or forgets a sender,
+// datagrams of that sender can be replayed once until they are older than MaxAge.
+// A sender is only forgotten before its last datagram expired if more than maxSenders others were heard from since.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"rdt/internal/clock"
+	"rdt/internal/message"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	idSize     = 16
+	headerSize = idSize + 8 + 8
+	tagSize    = 16
+	// Overhead is the number of bytes sealing adds to a datagram
+	Overhead = headerSize + tagSize
+	// MinKeySize is the minimum size of a pre-shared key in bytes
+	MinKeySize = 16
+	// MaxAge is how far the time a datagram was sealed may be from the time it is opened,
+	// so the clocks of peers must agree within it
+	MaxAge = 30 * time.Second
+	// maxSenders bounds the number of senders whose keys and replay windows are kept.
+	// Senders whose datagrams all expired are forgotten first, so it is far above the peers a transport serves.
+	maxSenders = 4096
+)
+
+var (
+	ErrAuth   = errors.New("datagram failed authentication")
+	ErrReplay = errors.New("datagram replayed or expired")
+)
+
+// ParseKey decodes a hex-encoded pre-shared key
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("pre-shared key must be hex-encoded: %w", err)
+	}
+	if len(key) < MinKeySize {
+		return nil, fmt.Errorf("pre-shared key of %d bytes must have at least %d bytes", len(key), MinKeySize)
+	}
+	return key, nil
+}
+
+// Codec seals messages encoded by another codec, implementing message.Codec.
+// Marshal and Unmarshal may be called concurrently with each other.
+type Codec struct {
+	inner message.Codec
+	psk   []byte
+	clock clock.Clock
+	// sealing
+	id       [idSize]byte
+	aead     cipher.AEAD
+	packetNo atomic.Uint64
+	// opening
+	mu       sync.Mutex
+	senders  map[[idSize]byte]*sender
+	rejected atomic.Uint64
+	replayed atomic.Uint64
+}
+
+// sender holds the state of a peer whose datagrams were authenticated
+type sender struct {
+	aead   cipher.AEAD
+	window replayWindow
+	latest time.Time // latest time of sealing of an accepted datagram
+}
+
+// NewCodec creates a codec that seals datagrams of inner with psk, timestamping them with clk
+func NewCodec(inner message.Codec, psk []byte, clk clock.Clock) (*Codec, error) {
+	if len(psk) < MinKeySize {
+		return nil, fmt.Errorf("pre-shared key of %d bytes must have at least %d bytes", len(psk), MinKeySize)
+	}
+	c := &Codec{
+		inner:   inner,
+		psk:     append([]byte(nil), psk...),
+		clock:   clk,
+		senders: make(map[[idSize]byte]*sender),
+	}
+	if _, err := rand.Read(c.id[:]); err != nil {
+		return nil, err
+	}
+	aead, err := c.newAEAD(c.id)
+	if err != nil {
+		return nil, err
+	}
+	c.aead = aead
+	return c, nil
+}
+
+// newAEAD creates the cipher of the sender with id
+func (c *Codec) newAEAD(id [idSize]byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, c.psk)
+	_, _ = mac.Write([]byte("rdt seal v2"))
+	_, _ = mac.Write(id[:])
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (c *Codec) Marshal(msg *message.Message) ([]byte, error) {
+	plaintext, err := c.inner.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	packetNo := c.packetNo.Add(1) - 1
+	data := make([]byte, headerSize, headerSize+len(plaintext)+tagSize)
+	copy(data, c.id[:])
+	binary.BigEndian.PutUint64(data[idSize:], packetNo)
+	binary.BigEndian.PutUint64(data[idSize+8:], uint64(c.clock.Now().UnixNano()))
+	return c.aead.Seal(data, nonce(packetNo), plaintext, data[:headerSize]), nil
+}
+
+// Unmarshal authenticates and decrypts data, failing with ErrAuth or ErrReplay
+// if the datagram was forged, corrupted, already received or sealed more than MaxAge from now
+func (c *Codec) Unmarshal(data []byte, msg *message.Message) error {
+	if len(data) < Overhead {
+		c.rejected.Add(1)
+		return ErrAuth
+	}
+	var id [idSize]byte
+	copy(id[:], data)
+	packetNo := binary.BigEndian.Uint64(data[idSize:])
+	sealed := time.Unix(0, int64(binary.BigEndian.Uint64(data[idSize+8:headerSize])))
+
+	c.mu.Lock()
+	s, known := c.senders[id]
+	c.mu.Unlock()
+	if !known {
+		aead, err := c.newAEAD(id)
+		if err != nil {
+			return err
+		}
+		s = &sender{aead: aead}
+	}
+	plaintext, err := s.aead.Open(nil, nonce(packetNo), data[headerSize:], data[:headerSize])
+	if err != nil {
+		c.rejected.Add(1)
+		return ErrAuth
+	}
+	now := c.clock.Now()
+	if sealed.Before(now.Add(-MaxAge)) || sealed.After(now.Add(MaxAge)) {
+		c.replayed.Add(1)
+		return ErrReplay
+	}
+	// only remember senders once they are authenticated, so forgeries allocate no state
+	c.mu.Lock()
+	if !known {
+		// another datagram of the sender may have been accepted meanwhile
+		if existing, found := c.senders[id]; found {
+			s = existing
+		} else {
+			if len(c.senders) >= maxSenders {
+				c.forgetSender(now)
+			}
+			c.senders[id] = s
+		}
+	}
+	fresh := s.window.accept(packetNo)
+	if fresh && sealed.After(s.latest) {
+		s.latest = sealed
+	}
+	c.mu.Unlock()
+	if !fresh {
+		c.replayed.Add(1)
+		return ErrReplay
+	}
+	return c.inner.Unmarshal(plaintext, msg)
+}
+
+// forgetSender forgets a sender to make room for another at now,
+// preferring one whose datagrams all expired and otherwise the one heard from least recently
+func (c *Codec) forgetSender(now time.Time) {
+	var oldest [idSize]byte
+	var oldestLatest time.Time
+	first := true
+	for id, s := range c.senders {
+		if s.latest.Before(now.Add(-MaxAge)) {
+			delete(c.senders, id)
+			return
+		}
+		if first || s.latest.Before(oldestLatest) {
+			oldest, oldestLatest, first = id, s.latest, false
+		}
+	}
+	delete(c.senders, oldest)
+}
+
+// Rejected obtains the number of datagrams that failed authentication
+func (c *Codec) Rejected() uint64 {
+	return c.rejected.Load()
+}
+
+// Replayed obtains the number of authentic datagrams dropped as replays or because they expired
+func (c *Codec) Replayed() uint64 {
+	return c.replayed.Load()
+}
+
+// nonce obtains the nonce of the datagram with packetNo
+func nonce(packetNo uint64) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n[4:], packetNo)
+	return n
+}
@@ -0,0 +1,89 @@
+package seal
+
+import (
+	"errors"
+	"rdt/internal/clock"
+	"rdt/internal/message"
+	"testing"
+	"time"
+)
+
+var psk = []byte("0123456789abcdef")
+
+func newTestCodec(t *testing.T, clk clock.Clock) *Codec {
+	t.Helper()
+	inner, err := message.ParseCodec("binary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCodec(inner, psk, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestReplay(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1000, 0))
+	sender := newTestCodec(t, clk)
+	data, err := sender.Marshal(&message.Message{Type: message.TypeData, SeqNo: 1, Payload: []byte("x")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiver := newTestCodec(t, clk)
+	var msg message.Message
+	if err := receiver.Unmarshal(data, &msg); err != nil {
+		t.Fatal("first datagram rejected:", err)
+	}
+	if err := receiver.Unmarshal(data, &msg); !errors.Is(err, ErrReplay) {
+		t.Fatalf("replay failed with %v, want %v", err, ErrReplay)
+	}
+
+	// a restarted receiver has no replay window, but rejects the datagram once it expired
+	clk.Advance(MaxAge + time.Second)
+	if err := newTestCodec(t, clk).Unmarshal(data, &msg); !errors.Is(err, ErrReplay) {
+		t.Fatalf("expired datagram failed with %v, want %v", err, ErrReplay)
+	}
+	if replayed := receiver.Replayed(); replayed != 1 {
+		t.Fatalf("counted %d replays, want 1", replayed)
+	}
+
+	// a datagram from a sender clock too far ahead is rejected as well
+	ahead := newTestCodec(t, clock.NewFake(clk.Now().Add(MaxAge+time.Second)))
+	data, err = ahead.Marshal(&message.Message{Type: message.TypeData, SeqNo: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.Unmarshal(data, &msg); !errors.Is(err, ErrReplay) {
+		t.Fatalf("datagram from the future failed with %v, want %v", err, ErrReplay)
+	}
+}
+
+func TestForgetExpiredSenderFirst(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1000, 0))
+	receiver := newTestCodec(t, clk)
+	stale := newTestCodec(t, clk)
+	var msg message.Message
+	open := func(c *Codec) {
+		t.Helper()
+		data, err := c.Marshal(&message.Message{Type: message.TypeAck})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := receiver.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	open(stale)
+	clk.Advance(MaxAge + time.Second)
+	for range maxSenders {
+		open(newTestCodec(t, clk))
+	}
+	if _, found := receiver.senders[stale.id]; found {
+		t.Fatal("expired sender kept while making room")
+	}
+	if len(receiver.senders) != maxSenders {
+		t.Fatalf("kept %d senders, want %d", len(receiver.senders), maxSenders)
+	}
+}
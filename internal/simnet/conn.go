@@ -0,0 +1,120 @@
+package simnet
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// packet is a datagram in flight
+type packet struct {
+	data []byte
+	src  netip.AddrPort
+}
+
+// PacketConn is an endpoint of a Network that implements udp.PacketConn
+type PacketConn struct {
+	net   *Network
+	laddr netip.AddrPort
+	queue chan packet
+	// linkFree is the time the endpoint finishes sending its last datagram, guarded by net.mu
+	linkFree time.Time
+	// lastArrival is the latest arrival time of datagrams sent to each destination, guarded by net.mu
+	lastArrival map[netip.AddrPort]time.Time
+
+	mu           sync.Mutex
+	readDeadline time.Time
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+func newPacketConn(n *Network, laddr netip.AddrPort, queueSize int) *PacketConn {
+	return &PacketConn{
+		net:         n,
+		laddr:       laddr,
+		queue:       make(chan packet, queueSize),
+		lastArrival: make(map[netip.AddrPort]time.Time),
+		closed:      make(chan struct{}),
+	}
+}
+
+// ReadFromUDPAddrPort waits for the next datagram and copies it into b,
+// truncating it if b is too small
+func (c *PacketConn) ReadFromUDPAddrPort(b []byte) (int, netip.AddrPort, error) {
+	c.mu.Lock()
+	deadline := c.readDeadline
+	c.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := deadline.Sub(c.net.cfg.Clock.Now())
+		if d <= 0 {
+			return 0, netip.AddrPort{}, c.opError("read", os.ErrDeadlineExceeded)
+		}
+		timer := c.net.cfg.Clock.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+
+	select {
+	case <-c.closed:
+		return 0, netip.AddrPort{}, c.opError("read", net.ErrClosed)
+	case <-timeout:
+		return 0, netip.AddrPort{}, c.opError("read", os.ErrDeadlineExceeded)
+	case pkt := <-c.queue:
+		return copy(b, pkt.data), pkt.src, nil
+	}
+}
+
+// WriteToUDPAddrPort sends b to addr. Like UDP it never blocks,
+// and datagrams to unbound addresses are silently dropped.
+func (c *PacketConn) WriteToUDPAddrPort(b []byte, addr netip.AddrPort) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, c.opError("write", net.ErrClosed)
+	default:
+	}
+	c.net.send(c, b, addr)
+	return len(b), nil
+}
+
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline has no effect since writes never block
+func (c *PacketConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func (c *PacketConn) LocalAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(c.laddr)
+}
+
+// Close unbinds the endpoint and unblocks pending reads
+func (c *PacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.net.remove(c)
+	})
+	return nil
+}
+
+// enqueue queues pkt for reading, returning false if the queue is full
+func (c *PacketConn) enqueue(pkt packet) bool {
+	select {
+	case c.queue <- pkt:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *PacketConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "udp", Addr: c.LocalAddr(), Err: err}
+}
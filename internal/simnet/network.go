@@ -0,0 +1,322 @@
+package simnet
+
+import (
+	"container/heap"
+	"errors"
+	"math/rand/v2"
+	"net/netip"
+	"rdt/internal/clock"
+	"rdt/internal/util"
+	"sync"
+	"time"
+)
+
+// Config describes the impairments applied to every datagram crossing a Network
+type Config struct {
+	Seed      uint64        // seed of the random number generator deciding the fate of each datagram
+	Loss      float64       // probability that a datagram is dropped
+	Duplicate float64       // probability that a datagram is delivered twice
+	Reorder   float64       // probability that a datagram is held back by an extra delay of up to ReorderDelay
+	Delay     time.Duration // one-way propagation delay
+	Jitter    time.Duration // maximum random variation added to Delay
+	// ReorderDelay is the maximum extra delay of reordered datagrams, defaulting to 2*Delay+Jitter
+	ReorderDelay time.Duration
+	// Bandwidth limits the rate at which each endpoint sends in bytes per second, where zero means unlimited
+	Bandwidth int
+	// QueueSize is the number of datagrams an endpoint buffers before dropping new ones
+	QueueSize int
+	// Clock drives delays and read deadlines, defaulting to clock.Real
+	Clock clock.Clock
+}
+
+// defaultQueueSize is the receive queue size of an endpoint if Config.QueueSize is zero
+const defaultQueueSize = 256
+
+// firstEphemeralPort is the first port allocated to endpoints bound to port 0
+const firstEphemeralPort = 49152
+
+var (
+	ErrAddrInUse = errors.New("address already in use")
+	ErrNoPorts   = errors.New("no ephemeral ports available")
+)
+
+// Network is an in-memory datagram network whose endpoints implement udp.PacketConn.
+// All randomness is drawn from a single generator seeded by Config.Seed,
+// so a network fed the same datagrams in the same order makes the same decisions.
+// Datagrams due at the same time are delivered in the order they were sent.
+type Network struct {
+	cfg Config
+
+	mu        sync.Mutex
+	rng       *rand.Rand
+	endpoints map[netip.AddrPort]*PacketConn
+	nextPort  uint16
+	stats     Stats
+	inFlight  schedule      // datagrams waiting for their delivery time
+	seq       uint64        // number of datagrams scheduled so far, breaking ties in inFlight
+	wakeup    chan struct{} // signals the delivery goroutine that inFlight changed
+	term      *util.Terminator
+}
+
+// Stats counts what happened to datagrams sent over a Network
+type Stats struct {
+	Sent       uint64 // datagrams written by endpoints
+	Delivered  uint64 // datagrams queued at their destination, including duplicates
+	Lost       uint64 // datagrams dropped by the configured loss rate
+	Duplicated uint64 // extra copies created
+	Reordered  uint64 // datagrams held back by an extra delay
+	Overflowed uint64 // datagrams dropped because the destination queue was full or missing
+}
+
+// New creates a network with impairments cfg
+func New(cfg Config) *Network {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real
+	}
+	if cfg.ReorderDelay <= 0 {
+		cfg.ReorderDelay = 2*cfg.Delay + cfg.Jitter
+	}
+	return &Network{
+		cfg:       cfg,
+		rng:       rand.New(rand.NewPCG(cfg.Seed, cfg.Seed^0x9e3779b97f4a7c15)),
+		endpoints: make(map[netip.AddrPort]*PacketConn),
+		nextPort:  firstEphemeralPort,
+		wakeup:    make(chan struct{}, 1),
+		term:      util.NewTerminator(),
+	}
+}
+
+// Start delivers datagrams until the network is stopped
+func (n *Network) Start() {
+	defer n.term.Done()
+	timer := n.cfg.Clock.NewTimer(0)
+	defer timer.Stop()
+	for {
+		// deliver all due datagrams and wait for the next one
+		n.mu.Lock()
+		now := n.cfg.Clock.Now()
+		for len(n.inFlight) > 0 && !n.inFlight[0].at.After(now) {
+			d := heap.Pop(&n.inFlight).(*delivery)
+			n.deliver(d.pkt, d.dst)
+		}
+		var wait <-chan time.Time
+		if len(n.inFlight) > 0 {
+			timer.Reset(n.inFlight[0].at.Sub(now))
+			wait = timer.C()
+		}
+		n.mu.Unlock()
+
+		select {
+		case <-n.term.Quit():
+			return
+		case <-n.wakeup:
+		case <-wait:
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C():
+			default:
+			}
+		}
+	}
+}
+
+// Stop stops delivering datagrams, dropping those still in flight
+func (n *Network) Stop() {
+	n.term.Terminate()
+}
+
+// Listen creates an endpoint bound to addr, allocating a port if the port of addr is zero.
+// An endpoint bound to an unspecified address receives datagrams sent to any address with its port.
+func (n *Network) Listen(addr netip.AddrPort) (*PacketConn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ip := addr.Addr()
+	if !ip.IsValid() || ip.IsUnspecified() {
+		ip = netip.IPv6Unspecified()
+	}
+	port := addr.Port()
+	if port != 0 && ip.IsUnspecified() && n.portInUse(port) {
+		return nil, ErrAddrInUse
+	}
+	if port == 0 {
+		for {
+			if n.nextPort == 0 {
+				return nil, ErrNoPorts
+			}
+			candidate := n.nextPort
+			n.nextPort++
+			if !n.portInUse(candidate) {
+				port = candidate
+				break
+			}
+		}
+	}
+	laddr := netip.AddrPortFrom(ip, port)
+	if _, ok := n.endpoints[laddr]; ok {
+		return nil, ErrAddrInUse
+	}
+	if _, ok := n.endpoints[netip.AddrPortFrom(netip.IPv6Unspecified(), port)]; ok {
+		return nil, ErrAddrInUse
+	}
+	c := newPacketConn(n, laddr, n.cfg.QueueSize)
+	n.endpoints[laddr] = c
+	return c, nil
+}
+
+// portInUse reports whether an endpoint is bound to port on any address
+func (n *Network) portInUse(port uint16) bool {
+	for addr := range n.endpoints {
+		if addr.Port() == port {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats obtains a snapshot of the datagram counters
+func (n *Network) Stats() Stats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stats
+}
+
+// remove unbinds the endpoint c
+func (n *Network) remove(c *PacketConn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.endpoints[c.laddr] == c {
+		delete(n.endpoints, c.laddr)
+	}
+}
+
+// send decides the fate of a datagram written by src and schedules its delivery
+func (n *Network) send(src *PacketConn, data []byte, dst netip.AddrPort) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stats.Sent++
+
+	// serialise datagrams of each endpoint at the configured bandwidth
+	now := n.cfg.Clock.Now()
+	departure := now
+	if n.cfg.Bandwidth > 0 {
+		if src.linkFree.After(now) {
+			departure = src.linkFree
+		}
+		departure = departure.Add(time.Duration(len(data)) * time.Second / time.Duration(n.cfg.Bandwidth))
+		src.linkFree = departure
+	}
+
+	// draw all random numbers up front so that each datagram consumes the same amount of randomness
+	lost := n.rng.Float64() < n.cfg.Loss
+	duplicated := n.rng.Float64() < n.cfg.Duplicate
+	reordered := n.rng.Float64() < n.cfg.Reorder
+	delays := [2]time.Duration{n.delay(reordered), n.delay(false)}
+
+	if lost {
+		n.stats.Lost++
+		return
+	}
+	if reordered {
+		n.stats.Reordered++
+	}
+	copies := 1
+	if duplicated {
+		n.stats.Duplicated++
+		copies = 2
+	}
+	for i := range copies {
+		at := departure.Add(delays[i])
+		if !reordered || i > 0 {
+			// jitter alone does not reorder datagrams on the same path
+			if last := src.lastArrival[dst]; at.Before(last) {
+				at = last
+			}
+			src.lastArrival[dst] = at
+		}
+		// copy data since the writer may reuse it
+		pkt := packet{data: append([]byte(nil), data...), src: sourceAddr(src.laddr, dst)}
+		heap.Push(&n.inFlight, &delivery{at: at, seq: n.seq, pkt: pkt, dst: dst})
+		n.seq++
+	}
+	select {
+	case n.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// delay draws the one-way delay of a datagram, with an extra delay if it is reordered.
+// It consumes randomness regardless of the configuration.
+func (n *Network) delay(reordered bool) time.Duration {
+	d := n.cfg.Delay
+	jitter, extra := n.rng.Float64(), n.rng.Float64()
+	d += time.Duration(jitter * float64(n.cfg.Jitter))
+	if reordered {
+		d += time.Duration(extra * float64(n.cfg.ReorderDelay))
+	}
+	return d
+}
+
+// deliver queues pkt at the endpoint bound to dst, dropping it if there is none or its queue is full.
+// It must be called with n.mu held.
+func (n *Network) deliver(pkt packet, dst netip.AddrPort) {
+	c, ok := n.endpoints[dst]
+	if !ok {
+		c, ok = n.endpoints[netip.AddrPortFrom(netip.IPv6Unspecified(), dst.Port())]
+	}
+	if !ok || !c.enqueue(pkt) {
+		n.stats.Overflowed++
+		return
+	}
+	n.stats.Delivered++
+}
+
+// sourceAddr obtains the source address of datagrams sent from laddr to dst.
+// Endpoints bound to an unspecified address send from the loopback address
+// in the family of dst, as if all endpoints were on one host.
+func sourceAddr(laddr, dst netip.AddrPort) netip.AddrPort {
+	if !laddr.Addr().IsUnspecified() {
+		return laddr
+	}
+	ip := dst.Addr()
+	switch {
+	case ip.Is4():
+		return netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), laddr.Port())
+	case ip.Is4In6():
+		return netip.AddrPortFrom(netip.AddrFrom16(netip.AddrFrom4([4]byte{127, 0, 0, 1}).As16()), laddr.Port())
+	default:
+		return netip.AddrPortFrom(netip.IPv6Loopback(), laddr.Port())
+	}
+}
+
+// delivery is a datagram scheduled for delivery at a point in time
+type delivery struct {
+	at  time.Time
+	seq uint64
+	pkt packet
+	dst netip.AddrPort
+}
+
+// schedule is a min-heap of deliveries ordered by time and then by sequence
+type schedule []*delivery
+
+func (s schedule) Len() int { return len(s) }
+func (s schedule) Less(i, j int) bool {
+	if s[i].at.Equal(s[j].at) {
+		return s[i].seq < s[j].seq
+	}
+	return s[i].at.Before(s[j].at)
+}
+func (s schedule) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s *schedule) Push(x any)   { *s = append(*s, x.(*delivery)) }
+func (s *schedule) Pop() any {
+	old := *s
+	d := old[len(old)-1]
+	old[len(old)-1] = nil
+	*s = old[:len(old)-1]
+	return d
+}
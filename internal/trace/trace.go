@@ -0,0 +1,115 @@
+// Package trace records the decisions of the protocol as a log of JSON lines,
+// one event per line, that can be replayed after the fact to explain a session.
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/netip"
+	"rdt/internal/clock"
+	"sync"
+	"time"
+)
+
+// Kind of event
+type Kind string
+
+const (
+	KindState       Kind = "state"        // connection entered state Reason
+	KindSend        Kind = "send"         // data message Seq sent for the first time
+	KindRetransmit  Kind = "retransmit"   // Count data messages resent from Seq because of Reason
+	KindWindow      Kind = "window"       // ack moved the send window base to Seq
+	KindTimerStart  Kind = "timer_start"  // retransmission timer of Seq started with RTO
+	KindTimerStop   Kind = "timer_stop"   // retransmission timer of Seq stopped by an ack
+	KindTimerExpire Kind = "timer_expire" // retransmission timer of Seq expired after RTO
+	KindAccept      Kind = "accept"       // data message Seq accepted by the receiver
+	KindDiscard     Kind = "discard"      // data message Seq discarded by the receiver because of Reason
+)
+
+// Event is a single decision of the protocol on a connection.
+// Fields that do not apply to its kind are zero and omitted.
+type Event struct {
+	Time     time.Time     `json:"time"`
+	Conn     uint64        `json:"conn"`
+	Peer     string        `json:"peer"`
+	Kind     Kind          `json:"event"`
+	Seq      uint32        `json:"seq"`
+	Count    uint32        `json:"count,omitempty"`     // messages retransmitted
+	InFlight uint32        `json:"in_flight,omitempty"` // messages sent but not acked
+	Window   uint32        `json:"window,omitempty"`    // effective send window
+	MaxSeqNo uint32        `json:"max_seq,omitempty"`   // size of the sequence no. space, given on state events
+	RTO      time.Duration `json:"rto,omitempty"`       // retransmission timeout in nanoseconds
+	Reason   string        `json:"reason,omitempty"`
+}
+
+// Tracer writes events as JSON lines, timestamped by its clock.
+// It is safe for concurrent use, and a nil *Tracer discards all events.
+type Tracer struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	clock clock.Clock
+	err   error // first write error, after which nothing more is written
+}
+
+// NewTracer creates a tracer that writes events to w
+func NewTracer(w io.Writer, clk clock.Clock) *Tracer {
+	return &Tracer{enc: json.NewEncoder(w), clock: clk}
+}
+
+// Conn obtains a tracer for the events of connection id with peer
+func (t *Tracer) Conn(id uint64, peer netip.AddrPort) *ConnTracer {
+	if t == nil {
+		return nil
+	}
+	return &ConnTracer{tracer: t, conn: id, peer: peer.String()}
+}
+
+// Err obtains the error that stopped tracing, if any
+func (t *Tracer) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+func (t *Tracer) write(e *Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = t.enc.Encode(e)
+	}
+}
+
+// ConnTracer writes the events of a single connection.
+// A nil *ConnTracer discards all events.
+type ConnTracer struct {
+	tracer *Tracer
+	conn   uint64
+	peer   string
+}
+
+// Emit writes e, stamped with the current time and the connection
+func (c *ConnTracer) Emit(e Event) {
+	if c == nil {
+		return
+	}
+	e.Time = c.tracer.clock.Now()
+	e.Conn = c.conn
+	e.Peer = c.peer
+	c.tracer.write(&e)
+}
+
+// Read decodes all events from r, which holds one JSON event per line
+func Read(r io.Reader) ([]Event, error) {
+	var events []Event
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var e Event
+		if err := dec.Decode(&e); err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+}
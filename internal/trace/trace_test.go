@@ -0,0 +1,78 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"rdt/internal/clock"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceRoundTrip(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1000, 0).UTC())
+	var buf bytes.Buffer
+	tracer := NewTracer(&buf, clk)
+	peer := netip.MustParseAddrPort("[::1]:4000")
+	a, b := tracer.Conn(1, peer), tracer.Conn(2, peer)
+
+	a.Emit(Event{Kind: KindState, Seq: 17, Window: 32, MaxSeqNo: 256, Reason: "established"})
+	clk.Advance(time.Millisecond)
+	a.Emit(Event{Kind: KindSend, Seq: 17, InFlight: 1})
+	b.Emit(Event{Kind: KindRetransmit, Seq: 3, Count: 4, InFlight: 4, Reason: "timeout"})
+	clk.Advance(time.Millisecond)
+	a.Emit(Event{Kind: KindTimerExpire, Seq: 17, RTO: 200 * time.Millisecond})
+	// a nil tracer and the tracers it hands out discard events
+	var none *Tracer
+	none.Conn(3, peer).Emit(Event{Kind: KindSend})
+	if err := tracer.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// one JSON object per line, with the fields that do not apply to the kind left out
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	wantKeys := [][]string{
+		{"time", "conn", "peer", "event", "seq", "window", "max_seq", "reason"},
+		{"time", "conn", "peer", "event", "seq", "in_flight"},
+		{"time", "conn", "peer", "event", "seq", "count", "in_flight", "reason"},
+		{"time", "conn", "peer", "event", "seq", "rto"},
+	}
+	if len(lines) != len(wantKeys) {
+		t.Fatalf("wrote %d lines, want %d", len(lines), len(wantKeys))
+	}
+	for i, line := range lines {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("line %d is not a JSON object: %v", i, err)
+		}
+		var keys []string
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		if len(keys) != len(wantKeys[i]) {
+			t.Fatalf("line %d has keys %v, want %v", i, keys, wantKeys[i])
+		}
+		for _, key := range wantKeys[i] {
+			if _, found := fields[key]; !found {
+				t.Fatalf("line %d has keys %v, want %v", i, keys, wantKeys[i])
+			}
+		}
+	}
+
+	events, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := clk.Now().Add(-2 * time.Millisecond)
+	want := []Event{
+		{Time: start, Conn: 1, Peer: "[::1]:4000", Kind: KindState, Seq: 17, Window: 32, MaxSeqNo: 256, Reason: "established"},
+		{Time: start.Add(time.Millisecond), Conn: 1, Peer: "[::1]:4000", Kind: KindSend, Seq: 17, InFlight: 1},
+		{Time: start.Add(time.Millisecond), Conn: 2, Peer: "[::1]:4000", Kind: KindRetransmit, Seq: 3, Count: 4, InFlight: 4, Reason: "timeout"},
+		{Time: start.Add(2 * time.Millisecond), Conn: 1, Peer: "[::1]:4000", Kind: KindTimerExpire, Seq: 17, RTO: 200 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("read %+v, want %+v", events, want)
+	}
+}
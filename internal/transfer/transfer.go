@@ -0,0 +1,375 @@
+// Package transfer sends files over a connection, such as an rdt.Conn.
+//
+// A transfer starts with Magic and a preamble line of JSON holding the name, size, mode and SHA-256 of the file.
+// The receiver answers with the offset to continue from, which is the size of a partial file
+// left by an interrupted transfer of the same content, after which the sender sends the rest of the file.
+// The receiver checks the SHA-256 of the whole file and answers with the outcome.
+// It never replaces an existing file, and only takes the read permissions of the file from the sender.
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Magic starts every transfer, distinguishing it from text typed by a user
+const Magic = "\x00RDTFILE1\n"
+
+// progressInterval is the least time between progress reports
+const progressInterval = time.Second
+
+// maxMode bounds the permissions a sender may give a received file,
+// which is always readable and writable by its owner
+const maxMode, ownerMode fs.FileMode = 0o644, 0o600
+
+var ErrChecksum = errors.New("file checksum mismatch")
+
+// Progress is reported while transferring, with done out of total bytes of the file transferred
+type Progress func(done, total int64)
+
+// preamble describes the file that is sent
+type preamble struct {
+	Name   string      `json:"name"`
+	Size   int64       `json:"size"`
+	Mode   fs.FileMode `json:"mode"`
+	SHA256 string      `json:"sha256"`
+}
+
+// reply is sent by the receiver after the preamble, with the offset to continue from, and after the file
+type reply struct {
+	Offset int64  `json:"offset"`
+	Error  string `json:"error,omitempty"`
+}
+
+// IsTransfer reports whether r starts with a transfer, waiting only for its first byte
+func IsTransfer(r *bufio.Reader) bool {
+	b, err := r.Peek(1)
+	return err == nil && b[0] == Magic[0]
+}
+
+// Send sends the file at path over conn, continuing where an interrupted transfer of the same file stopped.
+// It returns once the receiver has verified the file.
+func Send(conn io.ReadWriter, path string, progress Progress) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	p := preamble{
+		Name:   filepath.Base(path),
+		Size:   info.Size(),
+		Mode:   info.Mode().Perm(),
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}
+	if _, err := io.WriteString(conn, Magic); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(conn).Encode(p); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	start, err := readReply(r)
+	if err != nil {
+		return err
+	}
+	if start.Offset < 0 || start.Offset > p.Size {
+		return fmt.Errorf("receiver asked for offset %d of a file of %d bytes", start.Offset, p.Size)
+	}
+	if _, err := f.Seek(start.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	w := newProgressWriter(conn, start.Offset, p.Size, progress)
+	if _, err := io.CopyN(w, f, p.Size-start.Offset); err != nil {
+		return err
+	}
+	w.report()
+	_, err = readReply(r)
+	return err
+}
+
+// Receiver receives files into a directory
+type Receiver struct {
+	dir    string
+	mu     sync.Mutex
+	active map[string]*writer // partial files being written
+}
+
+// writer holds a partial file while its transfer is running
+type writer struct {
+	conn io.Closer     // connection of the transfer
+	done chan struct{} // closed once the partial file is released
+}
+
+// NewReceiver creates a receiver that stores files in dir
+func NewReceiver(dir string) *Receiver {
+	return &Receiver{dir: dir, active: make(map[string]*writer)}
+}
+
+// Receive receives a file from conn, read through r, which starts with a transfer,
+// and returns the path of the verified file, which is versioned as "name (n).ext" if name is taken.
+// Received data is kept in a partial file until verified, so that an interrupted transfer can be continued.
+// A transfer of the same file takes over from one still running, whose connection is closed,
+// since its sender has likely gone away without the connection having timed out yet.
+func (rc *Receiver) Receive(conn io.ReadWriteCloser, r *bufio.Reader, progress Progress) (string, error) {
+	path, err := rc.receive(conn, r, progress)
+	if err != nil {
+		// tell the sender, which may still be waiting for a reply
+		_ = Refuse(conn, err.Error())
+		return "", err
+	}
+	return path, json.NewEncoder(conn).Encode(reply{})
+}
+
+// Refuse answers a transfer with reason instead of receiving the file
+func Refuse(w io.Writer, reason string) error {
+	return json.NewEncoder(w).Encode(reply{Error: reason})
+}
+
+func (rc *Receiver) receive(conn io.ReadWriteCloser, r *bufio.Reader, progress Progress) (string, error) {
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return "", err
+	}
+	if string(magic) != Magic {
+		return "", errors.New("unsupported transfer")
+	}
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return "", fmt.Errorf("preamble: %w", err)
+	}
+	var p preamble
+	if err := json.Unmarshal(line, &p); err != nil {
+		return "", fmt.Errorf("preamble: %w", err)
+	}
+	name := filepath.Base(p.Name)
+	if name != p.Name || name == "." || name == ".." || !filepath.IsLocal(name) {
+		return "", fmt.Errorf("invalid file name %q", p.Name)
+	}
+	if p.Size < 0 {
+		return "", fmt.Errorf("invalid file size %d", p.Size)
+	}
+	sum, err := hex.DecodeString(p.SHA256)
+	if err != nil || len(sum) != sha256.Size {
+		return "", fmt.Errorf("invalid checksum %q", p.SHA256)
+	}
+
+	// partial files are named after their content, so only the same file continues one
+	partial := filepath.Join(rc.dir, fmt.Sprintf(".%s.%s.part", name, p.SHA256[:16]))
+	rc.acquire(partial, conn)
+	defer rc.release(partial)
+	f, h, offset, err := openPartial(partial, p.Size)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := json.NewEncoder(conn).Encode(reply{Offset: offset}); err != nil {
+		return "", err
+	}
+
+	pw := newProgressWriter(io.MultiWriter(f, h), offset, p.Size, progress)
+	if _, err := io.CopyN(pw, r, p.Size-offset); err != nil {
+		return "", err
+	}
+	pw.report()
+	if !bytes.Equal(h.Sum(nil), sum) {
+		// start over next time instead of continuing corrupt data
+		_ = f.Close()
+		_ = os.Remove(partial)
+		return "", ErrChecksum
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(partial, p.Mode.Perm()&maxMode|ownerMode); err != nil {
+		return "", err
+	}
+	path, err := reserve(rc.dir, name)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(partial, path); err != nil {
+		_ = os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// reserve creates an empty file in dir named name or, if that exists, the first free "name (n).ext",
+// returning its path
+func reserve(dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	base := name[:len(name)-len(ext)]
+	if base == "" {
+		// a hidden file such as .profile has no extension
+		base, ext = name, ""
+	}
+	for n := 1; ; n++ {
+		path := filepath.Join(dir, name)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			return path, f.Close()
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+		name = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+}
+
+// openPartial opens the partial file at path for appending the rest of a file of size bytes,
+// returning the hash of the data it already holds and its size
+func openPartial(path string, size int64) (*os.File, hash.Hash, int64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	h := sha256.New()
+	offset, err := io.Copy(h, f)
+	if err == nil && offset > size {
+		// not a prefix of the file, so start over
+		offset, err = 0, f.Truncate(0)
+		h.Reset()
+	}
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, 0, err
+	}
+	return f, h, offset, nil
+}
+
+// acquire takes over the partial file at path for the transfer on conn,
+// waiting until a transfer still writing it has stopped
+func (rc *Receiver) acquire(path string, conn io.Closer) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for {
+		w, ok := rc.active[path]
+		if !ok {
+			break
+		}
+		go w.conn.Close()
+		rc.mu.Unlock()
+		<-w.done
+		rc.mu.Lock()
+	}
+	rc.active[path] = &writer{conn: conn, done: make(chan struct{})}
+}
+
+// release hands the partial file at path to a waiting transfer
+func (rc *Receiver) release(path string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	close(rc.active[path].done)
+	delete(rc.active, path)
+}
+
+// readReply reads a reply of the receiver, failing with the error it reports
+func readReply(r *bufio.Reader) (reply, error) {
+	var rep reply
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return rep, fmt.Errorf("reply: %w", err)
+	}
+	if err := json.Unmarshal(line, &rep); err != nil {
+		return rep, fmt.Errorf("reply: %w", err)
+	}
+	if rep.Error != "" {
+		return rep, fmt.Errorf("receiver: %s", rep.Error)
+	}
+	return rep, nil
+}
+
+// progressWriter counts the bytes written to w and reports them at most every progressInterval
+type progressWriter struct {
+	w          io.Writer
+	done       int64
+	total      int64
+	progress   Progress
+	lastReport time.Time
+}
+
+func newProgressWriter(w io.Writer, done, total int64, progress Progress) *progressWriter {
+	pw := &progressWriter{w: w, done: done, total: total, progress: progress}
+	pw.report()
+	return pw
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.done += int64(n)
+	if time.Since(pw.lastReport) >= progressInterval {
+		pw.report()
+	}
+	return n, err
+}
+
+// report reports the bytes written so far
+func (pw *progressWriter) report() {
+	pw.lastReport = time.Now()
+	if pw.progress != nil {
+		pw.progress(pw.done, pw.total)
+	}
+}
+
+// Meter formats progress reports along with the rate since the first one.
+// The zero value is ready to use.
+type Meter struct {
+	start     time.Time
+	startDone int64
+}
+
+// Format formats done out of total bytes, such as "12.0 MiB / 100.0 MiB (12%) at 3.4 MiB/s"
+func (m *Meter) Format(done, total int64) string {
+	if m.start.IsZero() {
+		m.start, m.startDone = time.Now(), done
+	}
+	percent := int64(100)
+	if total > 0 {
+		percent = done * 100 / total
+	}
+	s := fmt.Sprintf("%s / %s (%d%%)", formatBytes(float64(done)), formatBytes(float64(total)), percent)
+	if elapsed := time.Since(m.start).Seconds(); elapsed > 0 && done > m.startDone {
+		s += fmt.Sprintf(" at %s/s", formatBytes(float64(done-m.startDone)/elapsed))
+	}
+	return s
+}
+
+// formatBytes formats n bytes with a binary unit
+func formatBytes(n float64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	i := -1
+	for ; n >= 1024 && i < len(units)-1; i++ {
+		n /= 1024
+	}
+	return fmt.Sprintf("%.1f %ciB", n, units[i])
+}
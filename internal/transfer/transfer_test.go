@@ -0,0 +1,78 @@
+package transfer
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// transfer sends the file at path to rc over a pipe, returning the path it was stored at
+func transfer(t *testing.T, rc *Receiver, path string) string {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	errs := make(chan error, 1)
+	go func() { errs <- Send(client, path, nil) }()
+	stored, err := rc.Receive(server, bufio.NewReader(server), nil)
+	if err != nil {
+		t.Fatal("receive:", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal("send:", err)
+	}
+	return stored
+}
+
+func TestReceiveKeepsExistingFiles(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	path := filepath.Join(src, "notes.txt")
+	if err := os.WriteFile(path, []byte("first"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rc := NewReceiver(dst)
+	transfer(t, rc, path)
+	if err := os.WriteFile(path, []byte("second"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stored := transfer(t, rc, path)
+
+	if want := filepath.Join(dst, "notes (1).txt"); stored != want {
+		t.Fatalf("stored at %s, want %s", stored, want)
+	}
+	for name, want := range map[string]string{"notes.txt": "first", "notes (1).txt": "second"} {
+		got, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("%s holds %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestReceiveMasksMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not kept on windows")
+	}
+	src := t.TempDir()
+	path := filepath.Join(src, "run.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile is subject to the umask
+	if err := os.Chmod(path, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	stored := transfer(t, NewReceiver(t.TempDir()), path)
+	info, err := os.Stat(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o644 {
+		t.Fatalf("stored with mode %o, want %o", mode, 0o644)
+	}
+}
@@ -0,0 +1,19 @@
+package udp
+
+import (
+	"log/slog"
+	"net/netip"
+	"rdt/internal/pcapng"
+	"time"
+)
+
+// record writes datagram to capture if packet capture is enabled,
+// reporting to logger the write error that stops the capture
+func record(capture *pcapng.Writer, logger *slog.Logger, t time.Time, dir pcapng.Direction, peer netip.AddrPort, datagram []byte) {
+	if capture == nil {
+		return
+	}
+	if err := capture.WriteDatagram(t, dir, peer, datagram); err != nil {
+		logger.Error("packet capture stopped", "err", err)
+	}
+}
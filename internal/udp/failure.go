@@ -0,0 +1,48 @@
+package udp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// failure records the first fatal I/O error of a goroutine and signals it by closing a channel
+type failure struct {
+	mu     sync.Mutex
+	err    error
+	failed chan struct{}
+}
+
+func newFailure() *failure {
+	return &failure{failed: make(chan struct{})}
+}
+
+// set records err unless an error was already recorded
+func (f *failure) set(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return
+	}
+	f.err = err
+	close(f.failed)
+}
+
+// Err obtains the recorded error, or nil if none occurred
+func (f *failure) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// fatal reports whether err breaks the socket itself rather than a single datagram,
+// as an unreachable destination, a full buffer or an ICMP error reported by a later read does
+func fatal(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.EBADF) || errors.Is(err, syscall.ENOTSOCK)
+}
+
+// Failed obtains a channel that is closed once an error is recorded
+func (f *failure) Failed() <-chan struct{} {
+	return f.failed
+}
@@ -0,0 +1,136 @@
+package udp
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"rdt/internal/clock"
+	"rdt/internal/message"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var peer = netip.MustParseAddrPort("127.0.0.1:9000")
+
+// stubConn fails the first reads and writes with the queued errors and then succeeds
+type stubConn struct {
+	mu         sync.Mutex
+	readErrs   []error
+	writeErrs  []error
+	datagrams  chan []byte // delivered to reads once readErrs are used up
+	written    chan []byte
+	readClosed chan struct{}
+}
+
+func newStubConn() *stubConn {
+	return &stubConn{datagrams: make(chan []byte, 4), written: make(chan []byte, 4), readClosed: make(chan struct{})}
+}
+
+func (c *stubConn) ReadFromUDPAddrPort(b []byte) (int, netip.AddrPort, error) {
+	c.mu.Lock()
+	if len(c.readErrs) > 0 {
+		err := c.readErrs[0]
+		c.readErrs = c.readErrs[1:]
+		c.mu.Unlock()
+		return 0, netip.AddrPort{}, err
+	}
+	c.mu.Unlock()
+	select {
+	case d := <-c.datagrams:
+		return copy(b, d), peer, nil
+	case <-c.readClosed:
+		return 0, netip.AddrPort{}, net.ErrClosed
+	case <-time.After(10 * time.Millisecond):
+		return 0, netip.AddrPort{}, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *stubConn) WriteToUDPAddrPort(b []byte, addr netip.AddrPort) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.writeErrs) > 0 {
+		err := c.writeErrs[0]
+		c.writeErrs = c.writeErrs[1:]
+		return 0, err
+	}
+	c.written <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+func (c *stubConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *stubConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *stubConn) LocalAddr() net.Addr              { return net.UDPAddrFromAddrPort(peer) }
+func (c *stubConn) Close() error                     { close(c.readClosed); return nil }
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestSenderDropsOnTransientError(t *testing.T) {
+	conn := newStubConn()
+	conn.writeErrs = []error{
+		&net.OpError{Op: "sendto", Net: "udp", Err: os.NewSyscallError("sendto", syscall.EINVAL)},
+		&net.OpError{Op: "sendto", Net: "udp", Err: os.NewSyscallError("sendto", syscall.ENETUNREACH)},
+	}
+	codec, _ := message.ParseCodec("binary")
+	ch := make(chan *message.AddressedMessage)
+	s := NewSender(conn, ch, codec, clock.Real, time.Second, discardLogger(), nil, nil)
+	go s.Start()
+
+	for seqNo := range uint32(3) {
+		ch <- message.NewDataMessage(peer, seqNo, []byte("x"))
+	}
+	select {
+	case <-conn.written:
+	case <-s.Failed():
+		t.Fatal("sender failed:", s.Err())
+	case <-time.After(time.Second):
+		t.Fatal("message after the failed ones was not sent")
+	}
+	// the datagram is counted after it is written
+	s.Stop()
+	if stats := s.Stats(); stats.Errors != 2 || stats.Packets != 1 {
+		t.Fatalf("counted %d errors and %d packets, want 2 and 1", stats.Errors, stats.Packets)
+	}
+}
+
+func TestReceiverSkipsTransientError(t *testing.T) {
+	conn := newStubConn()
+	conn.readErrs = []error{&net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("recvfrom", syscall.ECONNREFUSED)}}
+	codec, _ := message.ParseCodec("binary")
+	data, err := codec.Marshal(&message.NewDataMessage(peer, 1, []byte("x")).Message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.datagrams <- data
+	ch := make(chan *message.AddressedMessage)
+	r := NewReceiver(conn, ch, codec, clock.Real, time.Second, 2048, discardLogger(), nil, nil)
+	go r.Start()
+	defer r.Stop()
+
+	select {
+	case msg := <-ch:
+		if msg.SeqNo != 1 {
+			t.Fatalf("received seq %d, want 1", msg.SeqNo)
+		}
+	case <-r.Failed():
+		t.Fatal("receiver failed:", r.Err())
+	case <-time.After(time.Second):
+		t.Fatal("datagram after the error was not received")
+	}
+	if errs := r.Stats().Errors; errs != 1 {
+		t.Fatalf("counted %d errors, want 1", errs)
+	}
+
+	// a closed socket still stops the receiver
+	conn.Close()
+	select {
+	case <-r.Failed():
+	case <-time.After(time.Second):
+		t.Fatal("receiver did not fail on a closed socket")
+	}
+}
@@ -0,0 +1,20 @@
+package udp
+
+import (
+	"net"
+	"net/netip"
+	"time"
+)
+
+// PacketConn is the datagram socket used by Sender and Receiver.
+// It is implemented by *net.UDPConn and by simulated networks.
+type PacketConn interface {
+	ReadFromUDPAddrPort(b []byte) (n int, addr netip.AddrPort, err error)
+	WriteToUDPAddrPort(b []byte, addr netip.AddrPort) (int, error)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	LocalAddr() net.Addr
+	Close() error
+}
+
+var _ PacketConn = (*net.UDPConn)(nil)
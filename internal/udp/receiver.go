@@ -1,61 +1,109 @@
 package udp
 
 import (
+	"context"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"net"
+	"net/netip"
 	"os"
-	"rdt/internal/config"
+	"rdt/internal/clock"
 	"rdt/internal/message"
+	"rdt/internal/pcapng"
+	"rdt/internal/seal"
 	"rdt/internal/util"
 	"time"
 )
 
 type Receiver struct {
-	conn *net.UDPConn
-	ch   chan<- *message.AddressedMessage
+	conn  PacketConn
+	ch    chan<- *message.AddressedMessage
+	codec message.Codec
+	clock clock.Clock
+	// readTimeout bounds each read so that a stop request is noticed
+	readTimeout time.Duration
+	bufferSize  int // largest datagram that can be received
+	logger      *slog.Logger
+	connAttrs   func(peer netip.AddrPort) []slog.Attr // identifies the connection with peer in packet traces if not nil
+	capture     *pcapng.Writer                        // records every datagram received if not nil
+	*failure
+	counters
 	term *util.Terminator
 }
 
-// NewReceiver creates a UDP receiver that receives messages via conn
-// and sends processed messages to ch.
-func NewReceiver(conn *net.UDPConn, ch chan<- *message.AddressedMessage) *Receiver {
+// NewReceiver creates a UDP receiver that receives messages encoded with codec via conn
+// and sends processed messages to ch, with read deadlines taken from clk.
+// Every message received is traced to logger at debug level, with the attributes of its connection obtained from connAttrs if not nil,
+// and every datagram, even undecodable ones, is recorded in capture if not nil.
+// A read error that breaks the socket stops the receiver and is reported through Err and Failed,
+// while other read errors are counted and skipped.
+func NewReceiver(conn PacketConn, ch chan<- *message.AddressedMessage, codec message.Codec, clk clock.Clock, readTimeout time.Duration, bufferSize int, logger *slog.Logger, connAttrs func(peer netip.AddrPort) []slog.Attr, capture *pcapng.Writer) *Receiver {
 	return &Receiver{
-		conn: conn,
-		ch:   ch,
-		term: util.NewTerminator(),
+		conn:        conn,
+		ch:          ch,
+		codec:       codec,
+		clock:       clk,
+		readTimeout: readTimeout,
+		bufferSize:  bufferSize,
+		logger:      logger,
+		connAttrs:   connAttrs,
+		capture:     capture,
+		failure:     newFailure(),
+		term:        util.NewTerminator(),
 	}
 }
 
 func (r *Receiver) Start() {
 	defer r.term.Done()
-	buf := make([]byte, 16)
+	buf := make([]byte, r.bufferSize)
 	for {
 		select {
 		case <-r.term.Quit():
 			return
 		default:
 		}
-		_ = r.conn.SetReadDeadline(time.Now().Add(config.UDPReadTimeout))
+		_ = r.conn.SetReadDeadline(r.clock.Now().Add(r.readTimeout))
 		// read data
 		n, addr, err := r.conn.ReadFromUDPAddrPort(buf)
 		if err != nil {
 			if errors.Is(err, os.ErrDeadlineExceeded) {
 				continue
 			}
-			log.Printf("failed to recv message: %v", err)
+			if !fatal(err) {
+				// such as an ICMP error reported for an earlier datagram
+				r.logger.Warn("failed to receive datagram", "err", err)
+				r.errors.Add(1)
+				continue
+			}
+			// a closed socket is expected while stopping, but fails the receiver otherwise
+			if !errors.Is(err, net.ErrClosed) {
+				r.logger.Error("failed to receive datagram", "err", err)
+			}
+			r.set(fmt.Errorf("udp recv: %w", err))
 			return
 		}
+		r.count(n)
+		record(r.capture, r.logger, r.clock.Now(), pcapng.Inbound, addr, buf[:n])
 		// decode into message
 		msg := &message.AddressedMessage{
 			Message: message.Message{},
 			Addr:    addr,
 		}
-		if err := msg.UnmarshalText(buf[:n]); err != nil {
-			log.Printf("failed to unmarshal message: %v", err)
+		if err := r.codec.Unmarshal(buf[:n], &msg.Message); err != nil {
+			if message.IsCorrupt(err) {
+				r.logger.Warn("dropped corrupt datagram", "peer", addr, "err", err)
+			} else if errors.Is(err, seal.ErrAuth) || errors.Is(err, seal.ErrReplay) {
+				r.logger.Warn("dropped unauthentic datagram", "peer", addr, "err", err)
+			} else {
+				r.logger.Warn("failed to unmarshal message", "peer", addr, "err", err)
+			}
+			r.dropped.Add(1)
 			continue
 		}
-		log.Printf("recv: %-12q from %v\n", buf[:n], msg.Addr)
+		if r.logger.Enabled(context.Background(), slog.LevelDebug) {
+			r.logger.LogAttrs(context.Background(), slog.LevelDebug, "recv", traceAttrs(r.connAttrs, msg)...)
+		}
 		// forward to channel
 		select {
 		case <-r.term.Quit():
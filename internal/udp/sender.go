@@ -1,29 +1,52 @@
 package udp
 
 import (
+	"context"
 	"errors"
-	"log"
-	"net"
+	"fmt"
+	"log/slog"
+	"net/netip"
 	"os"
-	"rdt/internal/config"
+	"rdt/internal/clock"
 	"rdt/internal/message"
+	"rdt/internal/pcapng"
 	"rdt/internal/util"
 	"time"
 )
 
 type Sender struct {
-	conn *net.UDPConn
-	ch   <-chan *message.AddressedMessage
+	conn  PacketConn
+	ch    <-chan *message.AddressedMessage
+	codec message.Codec
+	clock clock.Clock
+	// writeTimeout bounds each write so that a stop request is noticed
+	writeTimeout time.Duration
+	logger       *slog.Logger
+	connAttrs    func(peer netip.AddrPort) []slog.Attr // identifies the connection with peer in packet traces if not nil
+	capture      *pcapng.Writer                        // records every datagram sent if not nil
+	*failure
+	counters
 	term *util.Terminator
 }
 
 // NewSender creates a UDP sender that receives processed messages from ch
-// and sends messages via conn.
-func NewSender(conn *net.UDPConn, ch <-chan *message.AddressedMessage) *Sender {
+// and sends messages encoded with codec via conn, with write deadlines taken from clk.
+// Every message sent is traced to logger at debug level, with the attributes of its connection obtained from connAttrs if not nil,
+// and, if capture is not nil, recorded in it.
+// A message that cannot be written is dropped, unless the error breaks the socket,
+// which is reported through Err and Failed, after which messages are discarded.
+func NewSender(conn PacketConn, ch <-chan *message.AddressedMessage, codec message.Codec, clk clock.Clock, writeTimeout time.Duration, logger *slog.Logger, connAttrs func(peer netip.AddrPort) []slog.Attr, capture *pcapng.Writer) *Sender {
 	return &Sender{
-		conn: conn,
-		ch:   ch,
-		term: util.NewTerminator(),
+		conn:         conn,
+		ch:           ch,
+		codec:        codec,
+		clock:        clk,
+		writeTimeout: writeTimeout,
+		logger:       logger,
+		connAttrs:    connAttrs,
+		capture:      capture,
+		failure:      newFailure(),
+		term:         util.NewTerminator(),
 	}
 }
 
@@ -35,9 +58,10 @@ func (s *Sender) Start() {
 			return
 		case msg := <-s.ch:
 			// encode from message
-			data, err := msg.MarshalText()
+			data, err := s.codec.Marshal(&msg.Message)
 			if err != nil {
-				log.Printf("failed to marshal message: %v", err)
+				s.logger.LogAttrs(context.Background(), slog.LevelError, "failed to marshal message", append(msg.LogAttrs(), slog.Any("err", err))...)
+				s.dropped.Add(1)
 				continue
 			}
 			// write data
@@ -47,17 +71,40 @@ func (s *Sender) Start() {
 					return
 				default:
 				}
-				_ = s.conn.SetWriteDeadline(time.Now().Add(config.UDPWriteTimeout))
+				_ = s.conn.SetWriteDeadline(s.clock.Now().Add(s.writeTimeout))
 				if _, err := s.conn.WriteToUDPAddrPort(data, msg.Addr); err != nil {
 					if errors.Is(err, os.ErrDeadlineExceeded) {
 						continue
 					}
-					log.Printf("failed to send message: %v", err)
+					if !fatal(err) {
+						// the peer will retransmit or time out, as if the datagram was lost
+						s.logger.Warn("dropped message that could not be sent", "peer", msg.Addr, "err", err)
+						s.errors.Add(1)
+						break
+					}
+					s.logger.Error("failed to send message", "peer", msg.Addr, "err", err)
+					s.set(fmt.Errorf("udp send to %v: %w", msg.Addr, err))
+					s.discard()
 					return
 				}
+				s.count(len(data))
+				record(s.capture, s.logger, s.clock.Now(), pcapng.Outbound, msg.Addr, data)
+				if s.logger.Enabled(context.Background(), slog.LevelDebug) {
+					s.logger.LogAttrs(context.Background(), slog.LevelDebug, "send", traceAttrs(s.connAttrs, msg)...)
+				}
 				break
 			}
-			log.Printf("send: %-12q to   %v\n", data, msg.Addr)
+		}
+	}
+}
+
+// discard drains ch until stopped so that producers never block on a failed sender
+func (s *Sender) discard() {
+	for {
+		select {
+		case <-s.term.Quit():
+			return
+		case <-s.ch:
 		}
 	}
 }
@@ -0,0 +1,35 @@
+package udp
+
+import "sync/atomic"
+
+// Stats is a snapshot of the datagrams that went through a Sender or Receiver
+type Stats struct {
+	Packets uint64 // datagrams sent or received
+	Bytes   uint64 // size of those datagrams
+	Dropped uint64 // datagrams received that could not be decoded, or messages that could not be encoded
+	Errors  uint64 // datagrams that could not be sent or received because of a socket error
+}
+
+// counters counts the datagrams of a Sender or Receiver
+type counters struct {
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+	dropped atomic.Uint64
+	errors  atomic.Uint64
+}
+
+// count records a datagram of n bytes
+func (c *counters) count(n int) {
+	c.packets.Add(1)
+	c.bytes.Add(uint64(n))
+}
+
+// Stats obtains a snapshot of the counters
+func (c *counters) Stats() Stats {
+	return Stats{
+		Packets: c.packets.Load(),
+		Bytes:   c.bytes.Load(),
+		Dropped: c.dropped.Load(),
+		Errors:  c.errors.Load(),
+	}
+}
@@ -0,0 +1,15 @@
+package udp
+
+import (
+	"log/slog"
+	"net/netip"
+	"rdt/internal/message"
+)
+
+// traceAttrs obtains the attributes that trace msg, preceded by those of its connection if connAttrs is not nil
+func traceAttrs(connAttrs func(peer netip.AddrPort) []slog.Attr, msg *message.AddressedMessage) []slog.Attr {
+	if connAttrs == nil {
+		return msg.LogAttrs()
+	}
+	return append(connAttrs(msg.Addr), msg.LogAttrs()...)
+}
@@ -0,0 +1,99 @@
+package rdt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"rdt/internal/gbn"
+	"rdt/internal/message"
+	"sync"
+	"time"
+)
+
+// Listener implements net.Listener on top of a server gbn.Transport
+type Listener struct {
+	transport    *gbn.Transport
+	closeTimeout time.Duration // bounds Close, see Config.CloseTimeout
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+func newListener(transport *gbn.Transport, closeTimeout time.Duration) *Listener {
+	return &Listener{
+		transport:    transport,
+		closeTimeout: closeTimeout,
+		closed:       make(chan struct{}),
+	}
+}
+
+// Accept waits for the next peer and returns its connection
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case <-l.closed:
+		return nil, &net.OpError{Op: "accept", Net: network, Addr: l.Addr(), Err: net.ErrClosed}
+	case <-l.transport.Done():
+		err := l.transport.Err()
+		if errors.Is(err, gbn.ErrTransportClosed) {
+			err = net.ErrClosed
+		}
+		return nil, &net.OpError{Op: "accept", Net: network, Addr: l.Addr(), Err: err}
+	case conn := <-l.transport.AcceptChan():
+		return newConn(conn, l.transport.LocalAddr(), l.closeTimeout, conn.Close, conn.Abort), nil
+	}
+}
+
+// Close stops the listener and gracefully closes all accepted connections,
+// aborting those that do not close within Config.CloseTimeout
+func (l *Listener) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), l.closeTimeout)
+	defer cancel()
+	return l.CloseContext(ctx)
+}
+
+// CloseContext stops the listener and gracefully closes all accepted connections,
+// aborting those that are still open once ctx expires
+func (l *Listener) CloseContext(ctx context.Context) error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.transport.Close(ctx)
+	})
+	if err != nil {
+		return &net.OpError{Op: "close", Net: network, Addr: l.Addr(), Err: err}
+	}
+	return nil
+}
+
+// Abort stops the listener and all accepted connections immediately without notifying peers
+func (l *Listener) Abort() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	l.transport.Abort()
+	return nil
+}
+
+// Broadcast writes b to every connection accepted by the listener that is still open,
+// split into payloads of at most message.MaxPayloadSize bytes.
+// Unlike Write on a single connection, it must be requested explicitly.
+func (l *Listener) Broadcast(ctx context.Context, b []byte) error {
+	for len(b) > 0 {
+		size := min(len(b), message.MaxPayloadSize)
+		// copy payload since the caller may reuse b
+		p := append([]byte(nil), b[:size]...)
+		if err := l.transport.Broadcast(ctx, p); err != nil {
+			return &net.OpError{Op: "broadcast", Net: network, Addr: l.Addr(), Err: err}
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+// Stats obtains a snapshot of the counters and gauges of the listener and all of its connections
+func (l *Listener) Stats() Stats {
+	return l.transport.Stats()
+}
+
+func (l *Listener) Addr() net.Addr {
+	return Addr{l.transport.LocalAddr()}
+}
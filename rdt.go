@@ -0,0 +1,108 @@
+// Package rdt exposes the reliable data transfer protocol through the standard net.Conn
+// and net.Listener interfaces, so it can be used wherever TCP would be.
+package rdt
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"rdt/internal/gbn"
+	"strconv"
+)
+
+// Config holds the protocol settings of a connection or listener
+type Config = gbn.Config
+
+// Mode selects the pipelining protocol
+type Mode = gbn.Mode
+
+const (
+	GoBackN         = gbn.GoBackN
+	SelectiveRepeat = gbn.SelectiveRepeat
+)
+
+// Stats is a snapshot of the counters and gauges of a listener or dialed connection and its transport
+type Stats = gbn.TransportStats
+
+// ConnStats is a snapshot of the counters and gauges of a single connection
+type ConnStats = gbn.ConnStats
+
+// DefaultConfig obtains the default protocol settings
+func DefaultConfig() Config {
+	return gbn.DefaultConfig()
+}
+
+// Dial opens a connection to the server at addr, given in host:port form, using the default settings
+func Dial(ctx context.Context, addr string) (net.Conn, error) {
+	return DialConfig(ctx, addr, DefaultConfig())
+}
+
+// DialConfig opens a connection to the server at addr, given in host:port form, using cfg
+func DialConfig(ctx context.Context, addr string, cfg Config) (net.Conn, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	raddr, err := ResolveAddr(ctx, addr)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	transport, err := gbn.NewClientTransport(cfg)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: Addr{raddr}, Err: err}
+	}
+	transport.Start()
+	conn, err := transport.Dial(ctx, raddr)
+	if err != nil {
+		transport.Abort()
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: Addr{raddr}, Err: err}
+	}
+	// the transport only serves this connection, so closing it closes the connection
+	return newConn(conn, transport.LocalAddr(), cfg.CloseTimeout, transport.Close, transport.Abort), nil
+}
+
+// Listen binds to the local address addr, given in host:port form,
+// and accepts one connection per peer using the default settings
+func Listen(addr string) (net.Listener, error) {
+	return ListenConfig(addr, DefaultConfig())
+}
+
+// ListenConfig binds to the local address addr, given in host:port form,
+// and accepts one connection per peer using cfg
+func ListenConfig(addr string, cfg Config) (net.Listener, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, &net.OpError{Op: "listen", Net: network, Err: err}
+	}
+	laddr, err := ResolveAddr(context.Background(), addr)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: network, Err: err}
+	}
+	transport, err := gbn.NewServerTransport(laddr, cfg)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: network, Addr: Addr{laddr}, Err: err}
+	}
+	transport.Start()
+	return newListener(transport, cfg.CloseTimeout), nil
+}
+
+// ResolveAddr resolves addr, given in host:port form, into a single address, preferring the first IP the host resolves to.
+// An empty host resolves to the unspecified address.
+func ResolveAddr(ctx context.Context, addr string) (netip.AddrPort, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if host == "" {
+		return netip.AddrPortFrom(netip.IPv6Unspecified(), uint16(port)), nil
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	// map IPv4 addresses into IPv6 to match the dual-stack socket
+	ip := netip.AddrFrom16(ips[0].As16())
+	return netip.AddrPortFrom(ip, uint16(port)), nil
+}
@@ -0,0 +1,207 @@
+package rdt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// testConfig obtains settings for connections over the loopback interface that stop quickly
+func testConfig() Config {
+	cfg := DefaultConfig()
+	cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg.UDPReadTimeout = 10 * time.Millisecond
+	cfg.UDPWriteTimeout = 10 * time.Millisecond
+	cfg.TimeWaitDuration = 10 * time.Millisecond
+	return cfg
+}
+
+// connect listens on the loopback interface and dials the listener with cfg,
+// returning the listener and both ends of the connection, which are aborted when the test ends
+func connect(t *testing.T, cfg Config) (*Listener, *Conn, *Conn) {
+	t.Helper()
+	ln, err := ListenConfig("[::1]:0", cfg)
+	if err != nil {
+		t.Fatal("listen:", err)
+	}
+	l := ln.(*Listener)
+	t.Cleanup(func() { l.Abort() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dialed, err := DialConfig(ctx, l.Addr().String(), cfg)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	c := dialed.(*Conn)
+	t.Cleanup(func() { c.Abort() })
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal("accept:", err)
+	}
+	s := accepted.(*Conn)
+	t.Cleanup(func() { s.Abort() })
+	return l, c, s
+}
+
+func TestDialListen(t *testing.T) {
+	l, c, s := connect(t, testConfig())
+	if c.RemoteAddr().String() != l.Addr().String() {
+		t.Fatalf("dialed %v, want %v", c.RemoteAddr(), l.Addr())
+	}
+	if s.RemoteAddr().(Addr).Port() != c.LocalAddr().(Addr).Port() {
+		t.Fatalf("accepted peer %v, want port of %v", s.RemoteAddr(), c.LocalAddr())
+	}
+
+	// more than one payload in each direction
+	request := bytes.Repeat([]byte("ping"), 1000)
+	response := bytes.Repeat([]byte("pong"), 1000)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Write(request)
+		errc <- err
+	}()
+	got := make([]byte, len(request))
+	if _, err := io.ReadFull(s, got); err != nil {
+		t.Fatal("server read:", err)
+	}
+	if !bytes.Equal(got, request) {
+		t.Fatal("server read different data")
+	}
+	if err := <-errc; err != nil {
+		t.Fatal("client write:", err)
+	}
+	if _, err := s.Write(response); err != nil {
+		t.Fatal("server write:", err)
+	}
+	got = make([]byte, len(response))
+	if _, err := io.ReadFull(c, got); err != nil {
+		t.Fatal("client read:", err)
+	}
+	if !bytes.Equal(got, response) {
+		t.Fatal("client read different data")
+	}
+}
+
+// expectTimeout fails the test unless err reports an exceeded deadline
+func expectTimeout(t *testing.T, op string, err error) {
+	t.Helper()
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("%s failed with %v, want %v", op, err, os.ErrDeadlineExceeded)
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("%s failed with %v, which is not a timeout", op, err)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	_, c, s := connect(t, testConfig())
+	buf := make([]byte, 16)
+
+	start := time.Now()
+	if err := s.SetReadDeadline(start.Add(50 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Read(buf)
+	expectTimeout(t, "read", err)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("read returned after %v, before the deadline", elapsed)
+	}
+
+	// the deadline keeps failing reads until it is moved
+	_, err = s.Read(buf)
+	expectTimeout(t, "read after the deadline", err)
+	if err := s.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("late")); err != nil {
+		t.Fatal("write:", err)
+	}
+	n, err := s.Read(buf)
+	if err != nil || string(buf[:n]) != "late" {
+		t.Fatalf("read %q and %v after clearing the deadline, want %q", buf[:n], err, "late")
+	}
+
+	// a deadline in the past fails a read at once
+	if err := s.SetDeadline(time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Read(buf)
+	expectTimeout(t, "read with a past deadline", err)
+}
+
+func TestWriteDeadline(t *testing.T) {
+	// small buffers, so that writes block soon once the peer stops reading
+	cfg := testConfig()
+	cfg.WindowSize = 2
+	cfg.ReceiveBufferSize = 1
+	cfg.InputChanBufferSize = 1
+	cfg.OutputChanBufferSize = 1
+	_, c, _ := connect(t, cfg)
+
+	if err := c.SetWriteDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 20*1200)
+	n, err := c.Write(data)
+	expectTimeout(t, "write", err)
+	if n == 0 || n == len(data) {
+		t.Fatalf("wrote %d of %d bytes before the deadline, want some", n, len(data))
+	}
+}
+
+func TestReadEOFAfterPeerClose(t *testing.T) {
+	_, c, s := connect(t, testConfig())
+	data := bytes.Repeat([]byte("x"), 5000)
+	if _, err := c.Write(data); err != nil {
+		t.Fatal("write:", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal("close:", err)
+	}
+
+	// all data written before the close is read before the EOF
+	got, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatal("read:", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("read %d bytes that differ from the %d written", len(got), len(data))
+	}
+	if n, err := s.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatalf("read %d bytes and %v after EOF, want %v", n, err, io.EOF)
+	}
+}
+
+func TestClose(t *testing.T) {
+	l, c, s := connect(t, testConfig())
+	if err := c.Close(); err != nil {
+		t.Fatal("close:", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal("second close:", err)
+	}
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("read after close failed with %v, want %v", err, net.ErrClosed)
+	}
+	if _, err := c.Write([]byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("write after close failed with %v, want %v", err, net.ErrClosed)
+	}
+	if _, err := s.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("peer read failed with %v, want %v", err, io.EOF)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal("close listener:", err)
+	}
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("accept after close failed with %v, want %v", err, net.ErrClosed)
+	}
+}